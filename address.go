@@ -36,14 +36,11 @@ func NewPrivateKey() *WalletPrivateKey {
 	tsk := RandomInt()
 	psk := RandomInt()
 
-	tskx, tsky := CURVE.Params().ScalarBaseMult(tsk.Bytes())
-	pskx, psky := CURVE.Params().ScalarBaseMult(psk.Bytes())
-
 	w := &WalletPrivateKey{
 		WalletTrackingKey: WalletTrackingKey{
 			WalletPublicKey: WalletPublicKey{
-				TPK: ECCPoint{tskx, tsky},
-				PPK: ECCPoint{pskx, psky},
+				TPK: CURVE.BaseMult(tsk.Bytes()),
+				PPK: CURVE.BaseMult(psk.Bytes()),
 			},
 			TSK: tsk,
 		},
@@ -72,5 +69,9 @@ func (w *WalletPrivateKey) Json() []byte {
 }
 
 func (w *WalletPublicKey) Hash() SHA256Sum {
-	return Hash(w.Json())
+	data := []byte{}
+	data = append(data, w.TPK.Bytes()...)
+	data = append(data, w.PPK.Bytes()...)
+
+	return Hash(data)
 }
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"math/big"
 	"time"
 )
 
@@ -19,23 +20,29 @@ type BlockHeader struct {
 	PrevHash   SHA256Sum `json:"prev_hash"`
 	MerkleRoot SHA256Sum `json:"merkle_root"`
 	Time       time.Time `json:"time"`
-	Difficulty uint64    `json:"difficulty"`
-	Nonce      uint64    `json:"nonce:"`
+	Bits       uint32    `json:"bits"`
+	Nonce      uint64    `json:"nonce"`
 }
 
 /*
- * Checks PoW, Time, and Genesis Hash.
+ * Checks PoW, Time, and Genesis Hash.  Headers more than the network's
+ * `MaxFutureDrift` ahead of network-adjusted time are rejected, as is any
+ * SeqNum 0 header other than the network's genesis.
  */
-func ValidHeader(header BlockHeader) bool {
+func (c *Client) ValidHeader(header BlockHeader) bool {
 	if !header.ValidPoW() {
 		return false
 	}
 
-	if header.Time.Add(2 * time.Hour).Before(time.Now()) {
+	if header.Target().Cmp(c.Params.PowLimit()) > 0 {
 		return false
 	}
 
-	if (header.SeqNum == 0) && (header.PrevHash != SHA256Sum{}) {
+	if header.Time.After(c.AdjustedTime().Add(c.Params.MaxFutureDrift)) {
+		return false
+	}
+
+	if header.SeqNum == 0 && header.Hash() != c.Params.GenesisHash {
 		return false
 	}
 
@@ -43,36 +50,30 @@ func ValidHeader(header BlockHeader) bool {
 }
 
 /*
- * Checks that a block header's hash is lower than the claimed difficulty.
+ * Checks that a block header's hash is no greater than its target.
  */
 func (bh BlockHeader) ValidPoW() bool {
-	difficulty := bh.Difficulty
-	zeroBytes := difficulty / 8
-	bitOffset := difficulty % 8
+	target := bh.Target()
+	if target.Sign() <= 0 {
+		return false
+	}
 
 	h := bh.Hash()
-	for i, c := range h {
-		if uint64(i) < zeroBytes {
-			if c != 0 {
-				return false
-			}
-		} else {
-			break
-		}
-	}
+	return h.Int().Cmp(target) <= 0
+}
 
-	c := h[zeroBytes]
-	for j := 0; j < 8; j++ {
-		if uint64(j) < bitOffset {
-			if (c >> (7 - uint64(j)) & 1) != 0 {
-				return false
-			}
-		} else {
-			break
-		}
-	}
+/*
+ * Expands the header's compact `Bits` into the full 256-bit target.
+ */
+func (bh BlockHeader) Target() *big.Int {
+	return CompactToBig(bh.Bits)
+}
 
-	return true
+/*
+ * The expected number of hashes needed to find the header.
+ */
+func (bh BlockHeader) Work() *big.Int {
+	return CalcWork(bh.Target())
 }
 
 /*
@@ -103,54 +104,25 @@ func (b Block) Json() []byte {
  * coinbase txn is sent to the address provided.
  */
 func (c *Client) NewBlock(prev BlockHeader, address WalletPublicKey) Block {
-	seqNum := prev.SeqNum + 1
-
-	// Gather txns and add fees
-	txns := c.TxnsFromPool()
-	fees := uint64(0)
-	for _, t := range txns {
-		fees += t.Body.Fee
-	}
-
-	// Create new coinbase commitment
-	coinbaseTxn := NewCoinbaseTxn(address, seqNum, fees)
-
-	block := Block{
-		Header: BlockHeader{
-			SeqNum:     seqNum,
-			PrevHash:   prev.Hash(),
-			MerkleRoot: SHA256Sum{},
-			Time:       time.Now(),
-			Difficulty: INITIAL_DIFFICULTY,
-			Nonce:      0,
-		},
-		Txns: []Txn{
-			coinbaseTxn,
-		},
-	}
-
-	block.Txns = append(block.Txns, txns...)
-
-	block.Header.MerkleRoot = block.MerkleHash()
-	block.Header.Difficulty = c.ComputeDifficulty(block)
-
-	return block
+	return c.NewBlockTemplate(prev).Block(address)
 }
 
 /*
- * Mines the Genesis block and sends the coinbase to `address`.
+ * Rebuilds the network's canonical Genesis block from its params.  The coinbase
+ * pays to a one-time key whose secret was discarded, so it can never be spent.
  */
-func GenesisBlock(address WalletPublicKey) Block {
-	coinbaseTxn := NewCoinbaseTxn(address, 0, 0)
+func GenesisBlock(params *ChainParams) Block {
+	coinbaseTxn := coinbaseTxn(params.GenesisPublicKey, params.GenesisDestKey,
+		params.CoinbaseValue(0))
 
 	b := Block{
 		Header: BlockHeader{
 			SeqNum:     0,
 			PrevHash:   SHA256Sum{},
 			MerkleRoot: SHA256Sum{},
-			Time:       time.Now(),
-			Difficulty: INITIAL_DIFFICULTY,
-			Nonce:      0,
+			Time:       params.GenesisTime,
+			Bits:       params.PowLimitBits,
+			Nonce:      params.GenesisNonce,
 		},
 		Txns: []Txn{
 			coinbaseTxn,
@@ -159,10 +131,6 @@ func GenesisBlock(address WalletPublicKey) Block {
 
 	b.Header.MerkleRoot = b.MerkleHash()
 
-	for !b.Header.ValidPoW() {
-		b.Header.Nonce += 1
-	}
-
 	return b
 }
 
@@ -175,6 +143,16 @@ func (c *Client) PrevalidBlock(b Block) bool {
 		return false
 	}
 
+	if len(b.Txns) > MAX_BLOCK_TXNS {
+		log.Println("Block exceeds MAX_BLOCK_TXNS")
+		return false
+	}
+
+	if b.Size() > MAX_BLOCK_SIZE {
+		log.Println("Block exceeds MAX_BLOCK_SIZE")
+		return false
+	}
+
 	if !c.ValidTxns(b) {
 		log.Println("Invalid txns")
 		return false
@@ -196,7 +174,10 @@ func (c *Client) PostValidBlock(b Block, mainPath, sidePath []SHA256Sum) bool {
 		return false
 	}
 
-	// Check median of last 11 blocks
+	if !c.ValidMedianTime(b.Header) {
+		log.Println("Block time not after median time past")
+		return false
+	}
 
 	if !c.VerifyTxns(b, mainPath, sidePath) {
 		return false
@@ -205,6 +186,13 @@ func (c *Client) PostValidBlock(b Block, mainPath, sidePath []SHA256Sum) bool {
 	return true
 }
 
+/*
+ * The serialized size of the block in bytes.
+ */
+func (b Block) Size() int {
+	return len(b.Bytes())
+}
+
 /*
  * Computes merkle hash and compares with block header.
  */
@@ -228,7 +216,7 @@ func (b Block) MerkleHash() SHA256Sum {
 	slots := make([]SHA256Sum, numSlots)
 	for i := 0; i < len(slots); i++ {
 		if i < len(b.Txns) {
-			slots[i] = Hash(b.Txns[i].Json())
+			slots[i] = b.Txns[i].Hash()
 		} else {
 			slots[i] = SHA256Sum{}
 		}
@@ -265,14 +253,11 @@ func (c *Client) WriteBlock(b Block) error {
 
 	// Build batched writes
 	pimgBatch := &db.Batch{}
-	txnPoolBatch := &db.Batch{}
 	for i, txn := range b.Txns {
 		// Only preimages for non-coinbase txns
 		if i != 0 {
 			pimgHash := Hash(txn.Sig.Preimage.Bytes()).Bytes()
 			pimgBatch.Put(pimgHash, pimgHash)
-			log.Println("Deleteing pimg from txn pool")
-			txnPoolBatch.Delete(pimgHash)
 		}
 	}
 
@@ -290,9 +275,11 @@ func (c *Client) WriteBlock(b Block) error {
 		return err
 	}
 
-	log.Println("Writing to txn pool batch")
-	// Write txn pool
-	err = c.dbm.txnPoolDB.Write(txnPoolBatch, nil)
+	// Record fees while the pool still knows how long each txn waited
+	c.RecordConfirmedFees(b)
+
+	log.Println("Removing confirmed txns from txn pool")
+	err = c.RemoveConfirmedTxns(b)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -317,10 +304,10 @@ func (b BlockHeader) Json() []byte {
 }
 
 /*
- * The hash to end all hashes.
+ * The hash to end all hashes, taken over the canonical encoding.
  */
 func (b BlockHeader) Hash() SHA256Sum {
-	return Hash(b.Json())
+	return Hash(b.Bytes())
 }
 
 /*
@@ -0,0 +1,51 @@
+package ozcoin
+
+import (
+	"testing"
+)
+
+func TestGenesisBlock(t *testing.T) {
+	defer UseParams(&RegTestParams)
+
+	for _, params := range []*ChainParams{&MainNetParams, &TestNetParams, &RegTestParams, &RegTestSecp256k1Params} {
+		err := UseParams(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		genesis := GenesisBlock(params)
+		if genesis.Header.Hash() != params.GenesisHash {
+			t.Errorf("%s genesis hash mismatch: %x", params.Name, genesis.Header.Hash())
+		}
+
+		if !genesis.Header.ValidPoW() {
+			t.Errorf("%s genesis has invalid PoW", params.Name)
+		}
+
+		if !genesis.VerifyMerkleHash() {
+			t.Errorf("%s genesis has invalid merkle root", params.Name)
+		}
+	}
+}
+
+func TestForeignGenesisRejected(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	genesis := GenesisBlock(c.Params)
+	if !c.ValidHeader(genesis.Header) {
+		t.Fatal("Canonical genesis header rejected")
+	}
+
+	// A freshly mined genesis with a different coinbase
+	foreign := GenesisBlock(c.Params)
+	foreign.Txns[0] = NewCoinbaseTxn(NewPrivateKey().PublicKey(), c.Params.CoinbaseValue(0))
+	foreign.Header.MerkleRoot = foreign.MerkleHash()
+	for !foreign.Header.ValidPoW() {
+		foreign.Header.Nonce++
+	}
+
+	if c.ValidHeader(foreign.Header) {
+		t.Error("Foreign genesis header accepted")
+	}
+}
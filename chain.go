@@ -1,115 +1,91 @@
 package ozcoin
 
 import (
+	db "github.com/syndtr/goleveldb/leveldb"
+
 	"errors"
 	"log"
+	"math/big"
 )
 
-func CoinbaseValue(seqnum uint64) uint64 {
-	return (50 * 100000000) >> (seqnum / 21000)
-}
-
-func (c *Client) MainChainTotalDifficulty() (uint64, error) {
-	total := uint64(0)
-	prevHash := c.LastHeader.Hash()
-	for {
-		header, err := c.GetHeader(prevHash)
-		if err != nil {
-			return 0, err
-		}
-
-		if header == nil {
-			return total, nil
-		}
-
-		total += header.Difficulty
-		prevHash = header.PrevHash
+/*
+ * Computes the compact target for `b`.  The target is only adjusted every
+ * `RetargetInterval` blocks, in proportion to how long the last interval took
+ * compared to `TargetTimespan`.
+ */
+func (c *Client) ComputeDifficulty(b Block) uint32 {
+	params := c.Params
+	if b.Header.SeqNum == 0 {
+		return params.PowLimitBits
 	}
-}
-
-func (c *Client) SideChainTotalDifficulty(hash SHA256Sum) (uint64, error) {
-	total := uint64(0)
-	prevHash := hash
-	for {
-		header, err := c.GetHeader(prevHash)
-		if err != nil {
-			return 0, err
-		}
 
-		if header != nil {
-			total += header.Difficulty
-			prevHash = header.PrevHash
-			continue
-		}
-
-		header, err = c.GetSideHeader(prevHash)
-		if err != nil {
-			return 0, err
-		}
-
-		if header != nil {
-			total += header.Difficulty
-			prevHash = header.PrevHash
-		}
-
-		return total, nil
+	prev, err := c.NthAncestorHeader(b.Header.PrevHash, 1)
+	if err != nil {
+		log.Println(err)
+		return 0
 	}
-}
 
-func (c *Client) ComputeDifficulty(b Block) uint64 {
-	if b.Header.SeqNum <= DIFFICULTY_SPACING {
-		return INITIAL_DIFFICULTY
+	if params.NoRetargeting || b.Header.SeqNum%params.RetargetInterval != 0 {
+		return prev.Bits
 	}
 
-	last, err := c.NthAncestorHeader(b.Header.PrevHash, DIFFICULTY_SPACING)
+	first, err := c.NthAncestorHeader(b.Header.PrevHash, int(params.RetargetInterval))
 	if err != nil {
 		log.Println(err)
-		return uint64(1) << 63
+		return 0
 	}
 
-	currTime := b.Header.Time.Unix()
-	lastTime := last.Time.Unix()
-
-	actualTime := currTime - lastTime
-	oldTarget := last.Difficulty
-
-	newTarget := uint64(float64(oldTarget) * (float64(TWO_WEEKS_SEC) /
-		float64(actualTime)))
-
-	lowerBound := uint64(0)
-	if oldTarget > 0 {
-		lowerBound = oldTarget - 1
+	// Limit adjustment to a factor of 4 in either direction
+	actualTime := prev.Time.Unix() - first.Time.Unix()
+	if actualTime < params.TargetTimespan/4 {
+		actualTime = params.TargetTimespan / 4
 	}
-	upperBound := oldTarget + 1
-
-	if newTarget < lowerBound {
-		newTarget = lowerBound
+	if actualTime > params.TargetTimespan*4 {
+		actualTime = params.TargetTimespan * 4
 	}
-	if newTarget > upperBound {
-		newTarget = upperBound
+
+	newTarget := prev.Target()
+	newTarget.Mul(newTarget, big.NewInt(actualTime))
+	newTarget.Div(newTarget, big.NewInt(params.TargetTimespan))
+
+	powLimit := params.PowLimit()
+	if newTarget.Cmp(powLimit) > 0 {
+		newTarget.Set(powLimit)
 	}
 
-	return newTarget
+	return BigToCompact(newTarget)
 }
 
 func (c *Client) ValidDifficulty(b Block) bool {
-	return uint64(b.Header.Difficulty) == c.ComputeDifficulty(b)
+	return b.Header.Bits == c.ComputeDifficulty(b)
 }
 
+/*
+ * Returns the header `n-1` blocks before `hash`, so that n = 1 returns the
+ * header of `hash` itself.  Side chain headers are walked until the main chain
+ * is reached, after which the height index is used.
+ */
 func (c *Client) NthAncestorHeader(hash SHA256Sum, n int) (*BlockHeader, error) {
 	prevHash := hash
 	prevHeader := &BlockHeader{}
 	depth := 0
 	for depth < n {
-		// Load previous header from main database
+		// Jump straight to the ancestor once on the main chain
 		header, err := c.GetHeader(prevHash)
-		if err != nil {
-			// Load previous header from sidechain database
-			header, err = c.GetSideHeader(prevHash)
-			if err != nil {
-				log.Println("Could not find nth ancestor", err)
-				return nil, err
+		if err == nil {
+			remaining := uint64(n - depth - 1)
+			if header.SeqNum < remaining {
+				return nil, errors.New("Ancestor is before genesis")
 			}
+
+			return c.HeaderAtHeight(header.SeqNum - remaining)
+		}
+
+		// Load previous header from sidechain database
+		header, err = c.GetSideHeader(prevHash)
+		if err != nil {
+			log.Println("Could not find nth ancestor", err)
+			return nil, err
 		}
 
 		prevHash = header.PrevHash
@@ -120,39 +96,100 @@ func (c *Client) NthAncestorHeader(hash SHA256Sum, n int) (*BlockHeader, error)
 	return prevHeader, nil
 }
 
-func (c *Client) ForkTxnsAndPreimages(path []SHA256Sum) (map[SHA256Sum]Output, map[SHA256Sum]struct{}, error) {
+/*
+ * Looks up the main chain header at `height` using the height index.
+ */
+func (c *Client) HeaderAtHeight(height uint64) (*BlockHeader, error) {
+	hash, err := c.GetHeightHash(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetHeader(hash)
+}
+
+/*
+ * Looks up the main chain block at `height` using the height index.
+ */
+func (c *Client) BlockAtHeight(height uint64) (*Block, error) {
+	hash, err := c.GetHeightHash(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.FindBlock(hash)
+}
+
+/*
+ * Rebuilds the height index by walking back from `LastHeader` to genesis.
+ */
+func (c *Client) ReindexHeights() error {
+	batch := &db.Batch{}
+	prevHash := c.LastHeader.Hash()
+	for {
+		header, err := c.GetHeader(prevHash)
+		if err != nil {
+			return err
+		}
+
+		batch.Put(HeightKey(header.SeqNum), prevHash[:])
+
+		if header.SeqNum == 0 {
+			break
+		}
+		prevHash = header.PrevHash
+	}
+
+	return c.dbm.heightDB.Write(batch, nil)
+}
+
+func (c *Client) ForkTxnsAndPreimages(path []SHA256Sum) (map[SHA256Sum]Output, map[SHA256Sum]struct{}, map[SHA256Sum]uint64, error) {
 
 	txns := make(map[SHA256Sum]Output)
 	pimgs := make(map[SHA256Sum]struct{})
+	heights := make(map[SHA256Sum]uint64)
 
 	for _, hash := range path {
-		b, err := c.GetBlock(hash)
+		b, err := c.FindBlock(hash)
 		if err != nil {
-			return nil, nil, err
-		}
-
-		if b == nil {
-			b, err = c.GetSideBlock(hash)
-			if err != nil {
-				return nil, nil, err
-			}
+			return nil, nil, nil, err
 		}
 
 		if b == nil {
-			return nil, nil, errors.New("Path does not exist")
+			return nil, nil, nil, errors.New("Path does not exist")
 		}
 
-		for _, txn := range b.Txns {
-			// Add preimage
-			pimgHash := Hash(txn.Sig.Preimage.Bytes())
-			pimgs[pimgHash] = SIGNAL
+		for i, txn := range b.Txns {
+			// Add preimage, coinbase txns have none
+			if i != 0 {
+				pimgHash := Hash(txn.Sig.Preimage.Bytes())
+				pimgs[pimgHash] = SIGNAL
+			}
 
 			// Add txn outputs
 			for _, output := range txn.Body.Outputs {
 				txns[output.Hash()] = output
+				heights[output.Hash()] = b.Header.SeqNum
 			}
 		}
 	}
 
-	return txns, pimgs, nil
+	return txns, pimgs, heights, nil
+}
+
+/*
+ * The height of the main chain block containing the output `hash`.
+ */
+func (c *Client) MainOutputHeight(hash SHA256Sum) (uint64, error) {
+	blockHash, err := c.MapToBlock(hash)
+	if err != nil {
+		return 0, err
+	}
+
+	header, err := c.GetHeader(blockHash)
+	if err != nil {
+		return 0, err
+	}
+
+	return header.SeqNum, nil
 }
@@ -0,0 +1,158 @@
+package ozcoin
+
+import (
+	"testing"
+)
+
+/*
+ * Checks that the height index maps every height of `chain`, which starts at
+ * genesis, to its header, and nothing past its tip.
+ */
+func checkTestHeights(t *testing.T, c *Client, chain []BlockHeader) {
+	for height, expected := range chain {
+		header, err := c.HeaderAtHeight(uint64(height))
+		if err != nil {
+			t.Fatal("Height", height, "not indexed:", err)
+		}
+
+		if header.Hash() != expected.Hash() {
+			t.Error("Wrong header at height", height)
+		}
+
+		block, err := c.BlockAtHeight(uint64(height))
+		if err != nil || block.Header.Hash() != expected.Hash() {
+			t.Error("Wrong block at height", height, err)
+		}
+	}
+
+	_, err := c.GetHeightHash(uint64(len(chain)))
+	if err == nil {
+		t.Error("Height past the tip is indexed")
+	}
+
+	tip := chain[len(chain)-1].Hash()
+	for n := 1; n <= len(chain); n++ {
+		ancestor, err := c.NthAncestorHeader(tip, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ancestor.Hash() != chain[len(chain)-n].Hash() {
+			t.Error("Wrong ancestor", n, "of the tip")
+		}
+	}
+
+	_, err = c.NthAncestorHeader(tip, len(chain)+1)
+	if err == nil {
+		t.Error("Found an ancestor before genesis")
+	}
+}
+
+/*
+ * Extends the main chain by `n` blocks, returning the headers of the whole
+ * main chain from genesis.
+ */
+func extendTestHeaders(t *testing.T, c *Client, n int) []BlockHeader {
+	extendTestChain(t, c, NewPrivateKey().PublicKey(), n)
+
+	chain := make([]BlockHeader, c.LastHeader.SeqNum+1)
+	for hash := c.LastHeader.Hash(); ; {
+		header, err := c.GetHeader(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		chain[header.SeqNum] = *header
+		if header.SeqNum == 0 {
+			break
+		}
+		hash = header.PrevHash
+	}
+
+	return chain
+}
+
+func TestHeightIndexAfterReorg(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := extendTestHeaders(t, c, 4)
+	checkTestHeights(t, c, chain)
+
+	// Fork off height 2, overtaking the main chain by one block
+	addr := NewPrivateKey().PublicKey()
+	fork := append([]BlockHeader{}, chain[:3]...)
+	for len(fork) <= len(chain) {
+		block := mineTestBlock(c, fork[len(fork)-1], addr, nil)
+		success, err := c.ExtendSideChain(block.Header, &block)
+		if !success || err != nil {
+			t.Fatal("Could not extend side chain:", err)
+		}
+
+		fork = append(fork, block.Header)
+	}
+
+	if c.LastHeader.Hash() != fork[len(fork)-1].Hash() {
+		t.Fatal("Heavier fork not adopted")
+	}
+	checkTestHeights(t, c, fork)
+
+	// Ancestors of the old tip are walked through the side chain back to
+	// the fork point
+	oldTip := chain[len(chain)-1].Hash()
+	for n := 1; n <= len(chain); n++ {
+		ancestor, err := c.NthAncestorHeader(oldTip, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ancestor.Hash() != chain[len(chain)-n].Hash() {
+			t.Error("Wrong ancestor", n, "of the old tip")
+		}
+	}
+
+	// Reindexing agrees with the index maintained through the reorg
+	err = c.ReindexHeights()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestHeights(t, c, fork)
+}
+
+func TestReindexHeightsWithoutIndex(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := extendTestHeaders(t, c, 5)
+
+	// Drop the whole index, as in databases written before it existed
+	iter := c.dbm.heightDB.NewIterator(nil, nil)
+	for iter.Next() {
+		err = c.dbm.heightDB.Delete(iter.Key(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	iter.Release()
+
+	_, err = c.HeaderAtHeight(0)
+	if err == nil {
+		t.Fatal("Height index not dropped")
+	}
+
+	// Loading the tip rebuilds the index
+	restarted := restartTestClient(t, c)
+	defer restarted.dbm.CloseConnections()
+
+	checkTestHeights(t, restarted, chain)
+}
@@ -1,7 +1,12 @@
 package ozcoin
 
 import (
+	"errors"
 	"log"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
 type ClientType uint8
@@ -16,15 +21,15 @@ var SIGNAL = struct{}{}
 /*
  * Stores full copies of every block and txn pool.
  */
-func NewBlockchain(clientAddress, walletAddress, password string) *Client {
-	return newClient(BLOCKCHAIN_CLIENT, clientAddress, walletAddress, password, false)
+func NewBlockchain(params *ChainParams, clientAddress, walletAddress, password string) *Client {
+	return newClient(params, BLOCKCHAIN_CLIENT, clientAddress, walletAddress, password, false)
 }
 
 /*
  * Only stores block headers and preimages.
  */
-func NewSPV(clientAddress, walletAddress, password string) *Client {
-	return newClient(SVP_CLIENT, clientAddress, walletAddress, password, true)
+func NewSPV(params *ChainParams, clientAddress, walletAddress, password string) *Client {
+	return newClient(params, SVP_CLIENT, clientAddress, walletAddress, password, true)
 }
 
 /*
@@ -35,8 +40,14 @@ func NewSPV(clientAddress, walletAddress, password string) *Client {
  */
 type Client struct {
 	Type               ClientType
+	Params             *ChainParams
 	LastHeader         BlockHeader
+	ChainWork          *big.Int
 	UpdateWallet       bool
+	TimeSource         *MedianTimeSource
+	MaxPoolSize        int
+	PoolExpiry         time.Duration
+	Fees               *FeeEstimator
 	Address            string
 	HeaderDBPath       string
 	SideHeaderDBPath   string
@@ -49,45 +60,68 @@ type Client struct {
 	PImgDBPath         string
 	PeerDBPath         string
 	TxnPoolDBPath      string
+	PoolPImgDBPath     string
+	HeightDBPath       string
+	WorkDBPath         string
+	FeeDBPath          string
 	Sources            []string
 	BlockHashChan      chan HashMsg
 	TxnHashChan        chan HashMsg
-	BlockChan          chan Block
-	TxnChan            chan Txn
+	BlockChan          chan BlockSubmission
+	TxnChan            chan TxnSubmission
+	TemplateChan       chan TemplateRequest
 	dbm                *DBManager
 	Wallet             *WalletClient
+	tipMtx             sync.Mutex
+	tipSubs            []chan SHA256Sum
 }
 
 /*
  * Builds a new client and starts the gossip rpc server.
  */
-func newClient(t ClientType, clientAddress, walletAddress, password string, updateWallet bool) *Client {
+func newClient(params *ChainParams, t ClientType, clientAddress, walletAddress, password string, updateWallet bool) *Client {
+	err := bindParams(params)
+	if err != nil {
+		log.Println(err)
+		panic("Unable to use network params")
+	}
+
 	client := &Client{
-		Type:               t,
-		UpdateWallet:       updateWallet,
-		HeaderDBPath:       "db/header.db",
-		SideHeaderDBPath:   "db/side-header.db",
-		OrphanHeaderDBPath: "db/orphan-header.db",
-		BlockDBPath:        "db/block.db",
-		SideBlockDBPath:    "db/side-block.db",
-		OrphanBlockDBPath:  "db/orphan-block.db",
-		MapDBPath:          "db/map.db",
-		PImgDBPath:         "db/pimg.db",
-		PeerDBPath:         "db/peer.db",
-		TxnPoolDBPath:      "db/txn-pool.db",
-		Address:            clientAddress,
-		Sources:            []string{},
-		BlockHashChan:      make(chan HashMsg),
-		TxnHashChan:        make(chan HashMsg),
-		BlockChan:          make(chan Block),
-		TxnChan:            make(chan Txn),
+		Type:          t,
+		Params:        params,
+		ChainWork:     &big.Int{},
+		UpdateWallet:  updateWallet,
+		TimeSource:    NewMedianTimeSource(),
+		MaxPoolSize:   MAX_POOL_SIZE,
+		PoolExpiry:    POOL_EXPIRY,
+		Fees:          NewFeeEstimator(),
+		Address:       clientAddress,
+		Sources:       []string{},
+		BlockHashChan: make(chan HashMsg),
+		TxnHashChan:   make(chan HashMsg),
+		BlockChan:     make(chan BlockSubmission),
+		TxnChan:       make(chan TxnSubmission),
+		TemplateChan:  make(chan TemplateRequest),
 		Wallet: &WalletClient{
 			Address: walletAddress,
 		},
 	}
+	client.SetDataDir(params.DataDir)
 	client.dbm = client.OpenDatabases()
 
-	err := client.Serve()
+	err = client.LoadLastHeader()
+	if err != nil {
+		log.Println("No chain tip found, starting from genesis")
+		err = client.InitGenesis()
+		if err != nil {
+			log.Println(err)
+			panic("Could not add genesis block")
+		}
+	} else {
+		log.Println("Resuming chain at height", client.LastHeader.SeqNum)
+	}
+
+	err = client.Serve()
 	if err != nil {
 		log.Println(err)
 		panic("Unable to start rpc server")
@@ -98,6 +132,133 @@ func newClient(t ClientType, clientAddress, walletAddress, password string, upda
 	return client
 }
 
+/*
+ * Places every client database inside `dir`.
+ */
+func (c *Client) SetDataDir(dir string) {
+	c.HeaderDBPath = filepath.Join(dir, "header.db")
+	c.SideHeaderDBPath = filepath.Join(dir, "side-header.db")
+	c.OrphanHeaderDBPath = filepath.Join(dir, "orphan-header.db")
+	c.BlockDBPath = filepath.Join(dir, "block.db")
+	c.SideBlockDBPath = filepath.Join(dir, "side-block.db")
+	c.OrphanBlockDBPath = filepath.Join(dir, "orphan-block.db")
+	c.MapDBPath = filepath.Join(dir, "map.db")
+	c.PImgDBPath = filepath.Join(dir, "pimg.db")
+	c.PeerDBPath = filepath.Join(dir, "peer.db")
+	c.TxnPoolDBPath = filepath.Join(dir, "txn-pool.db")
+	c.PoolPImgDBPath = filepath.Join(dir, "pool-pimg.db")
+	c.HeightDBPath = filepath.Join(dir, "height.db")
+	c.WorkDBPath = filepath.Join(dir, "work.db")
+	c.FeeDBPath = filepath.Join(dir, "fee.db")
+}
+
+/*
+ * Network-adjusted time, used to bound how far ahead block times may be.
+ */
+func (c *Client) AdjustedTime() time.Time {
+	return c.TimeSource.AdjustedTime()
+}
+
+/*
+ * Restores `LastHeader` from the chain tip saved in the header database.
+ */
+func (c *Client) LoadLastHeader() error {
+	tip, err := c.GetTip()
+	if err != nil {
+		return err
+	}
+
+	header, err := c.GetHeader(tip.Hash)
+	if err != nil {
+		return err
+	}
+
+	c.LastHeader = *header
+	c.ChainWork = tip.Work
+
+	// Chains saved before the height index existed need to be indexed
+	_, err = c.GetHeightHash(header.SeqNum)
+	if err != nil {
+		log.Println("Rebuilding height index")
+		err = c.ReindexHeights()
+		if err != nil {
+			return err
+		}
+	}
+
+	c.LoadFeeHistory()
+
+	return nil
+}
+
+/*
+ * Starts a new chain from the network's genesis block.
+ */
+func (c *Client) InitGenesis() error {
+	genesis := GenesisBlock(c.Params)
+	success, err := c.ExtendMainChain(genesis.Header, &genesis)
+	if err != nil {
+		return err
+	}
+
+	if !success {
+		return errors.New("Genesis block rejected")
+	}
+
+	return nil
+}
+
+/*
+ * Persists the new chain tip before updating `LastHeader`.
+ */
+func (c *Client) SetLastHeader(header BlockHeader, work *big.Int) error {
+	tip := ChainTip{
+		Hash: header.Hash(),
+		Work: work,
+	}
+
+	err := c.PutTip(tip)
+	if err != nil {
+		return err
+	}
+
+	c.LastHeader = header
+	c.ChainWork = work
+
+	c.notifyTip(tip.Hash)
+
+	return nil
+}
+
+/*
+ * Returns a channel that receives the hash of the main chain tip whenever it
+ * changes.  Each consumer needs its own subscription, otherwise consumers
+ * steal each other's signals.
+ */
+func (c *Client) SubscribeTip() <-chan SHA256Sum {
+	sub := make(chan SHA256Sum, 1)
+
+	c.tipMtx.Lock()
+	c.tipSubs = append(c.tipSubs, sub)
+	c.tipMtx.Unlock()
+
+	return sub
+}
+
+func (c *Client) notifyTip(hash SHA256Sum) {
+	c.tipMtx.Lock()
+	defer c.tipMtx.Unlock()
+
+	// Notify without blocking, only the latest tip is kept pending
+	for _, sub := range c.tipSubs {
+		select {
+		case <-sub:
+		default:
+		}
+		sub <- hash
+	}
+}
+
 /*
  * Checks to see if hash is recorded, otherwise spawns a goroutine to
  * resolve hash.
@@ -152,15 +313,19 @@ func (c *Client) run() {
 			frontier[req.Hash] = SIGNAL
 			go c.AddToTxnPool(req, startChan, doneChan)
 
-		case block := <-c.BlockChan:
+		case sub := <-c.BlockChan:
 			// New Block
-			frontier[block.Header.Hash()] = SIGNAL
-			go c.AdoptMinedBlock(block, startChan, doneChan)
+			frontier[sub.Block.Header.Hash()] = SIGNAL
+			go c.AdoptMinedBlock(sub, startChan, doneChan)
 
-		case txn := <-c.TxnChan:
+		case sub := <-c.TxnChan:
 			// New Txn
-			frontier[txn.Hash()] = SIGNAL
-			go c.AdoptTxn(txn, startChan, doneChan)
+			frontier[sub.Txn.Hash()] = SIGNAL
+			go c.AdoptTxn(sub, startChan, doneChan)
+
+		case req := <-c.TemplateChan:
+			// Block template for a miner
+			go c.ServeTemplateRequest(req, startChan, doneChan)
 
 		case hash := <-doneChan:
 			// Remove from frontier and signal next operation
@@ -171,25 +336,48 @@ func (c *Client) run() {
 	}
 }
 
+/*
+ * TxnSubmission
+ *
+ * A txn created by this client's wallet, along with a channel to report why it
+ * was rejected, if it was.
+ */
+type TxnSubmission struct {
+	Txn    Txn
+	Result chan error
+}
+
+/*
+ * Submits a new txn to the txn pool and waits for the outcome.
+ */
+func (c *Client) SubmitTxn(txn Txn) error {
+	sub := TxnSubmission{
+		Txn:    txn,
+		Result: make(chan error, 1),
+	}
+
+	c.TxnChan <- sub
+
+	return <-sub.Result
+}
+
 /*
  * Validates and adds a txn to the txn pool.
  */
-func (c *Client) AdoptTxn(txn Txn, startChan chan struct{}, doneChan chan SHA256Sum) {
+func (c *Client) AdoptTxn(sub TxnSubmission, startChan chan struct{}, doneChan chan SHA256Sum) {
 	_ = <-startChan
 
+	txn := sub.Txn
+
 	// Signal when complete
 	defer func() { doneChan <- txn.Hash() }()
 
 	log.Println("New txn:", string(txn.Json()))
 
-	if !ValidTxn(txn) && !ValidCoinbaseTxn(txn) {
-		log.Println("Invalid txn")
-		return
-	}
-
-	err := c.PutTxnPool(txn)
+	err := c.AdmitTxn(txn)
+	sub.Result <- err
 	if err != nil {
-		log.Println("Failed to add txn to txn pool")
+		log.Println("Txn rejected:", err)
 		return
 	}
 
@@ -204,49 +392,80 @@ func (c *Client) AdoptTxn(txn Txn, startChan chan struct{}, doneChan chan SHA256
 }
 
 /*
- * Validates and extends the main chain with mined block.
+ * BlockSubmission
+ *
+ * A mined block, along with a channel to report whether it was adopted.
+ */
+type BlockSubmission struct {
+	Block  Block
+	Result chan error
+}
+
+/*
+ * Sends a mined block through `BlockChan` and waits for the outcome.
+ */
+func (c *Client) SubmitBlock(block Block) error {
+	sub := BlockSubmission{
+		Block:  block,
+		Result: make(chan error, 1),
+	}
+
+	c.BlockChan <- sub
+
+	return <-sub.Result
+}
+
+/*
+ * Validates and extends the main chain with mined block, reporting the result
+ * to the submitter.
  */
-func (c *Client) AdoptMinedBlock(block Block, startChan chan struct{}, doneChan chan SHA256Sum) {
+func (c *Client) AdoptMinedBlock(sub BlockSubmission, startChan chan struct{}, doneChan chan SHA256Sum) {
 	_ = <-startChan
 
 	// Signal when complete
-	defer func() { doneChan <- block.Header.Hash() }()
+	defer func() { doneChan <- sub.Block.Header.Hash() }()
 
+	err := c.adoptMinedBlock(sub.Block)
+	if err != nil {
+		log.Println(err)
+	}
+
+	sub.Result <- err
+}
+
+func (c *Client) adoptMinedBlock(block Block) error {
 	log.Println("New block:", string(block.Json()))
 
-	if block.Header.SeqNum != 0 && c.LastHeader.Hash() != block.Header.PrevHash {
-		log.Println("Mined block rejected: PrevHash incorrect or not genesis block")
-		return
+	if c.LastHeader.Hash() != block.Header.PrevHash {
+		return errors.New("Mined block rejected: PrevHash incorrect")
 	}
 
-	if !ValidHeader(block.Header) {
-		log.Println("Mined block rejected: invalid header")
-		return
+	if !c.ValidHeader(block.Header) {
+		return errors.New("Mined block rejected: invalid header")
 	}
 	if !c.PrevalidBlock(block) {
-		log.Println("Mined block rejected: block prevalidation failed")
-		return
+		return errors.New("Mined block rejected: block prevalidation failed")
 	}
 
 	success, err := c.ExtendMainChain(block.Header, &block)
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
 
 	if !success {
-		log.Println("Failed to extend main chain")
-		return
+		return errors.New("Failed to extend main chain")
 	}
 
 	log.Println("Mined block accpeted, broadcasting block")
 	err = c.BcastBlock(block.Header.Hash())
 	if err != nil {
 		log.Println("Broadcast failed")
-		return
+		return nil
 	}
 
 	log.Println("Broadcast successful")
+
+	return nil
 }
 
 /*
@@ -271,14 +490,11 @@ func (c *Client) FilterBlock(req HashMsg) error {
  * Returns nil if client is already aware of txn.
  */
 func (c *Client) FilterTxn(req HashMsg) error {
-	// Load header from txn pool database
+	// Load txn from txn pool database
 	_, err := c.GetTxnPool(req.Hash)
 	if err != nil {
-		// Check for preimage
-		found := c.GetPreimage(req.Hash)
-		if found {
-			err = nil
-		}
+		// Check for confirmed txn
+		_, err = c.MapToBlock(req.Hash)
 	}
 
 	return err
@@ -0,0 +1,140 @@
+package ozcoin
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+ * Builds a regtest blockchain client backed by databases in a temporary
+ * directory, without starting the rpc server or run loop.  The returned func
+ * closes the databases and removes the directory.
+ */
+func newTestClient(t *testing.T) (*Client, func()) {
+	return newTestClientParams(t, RegTestParams)
+}
+
+/*
+ * Builds a test client on a copy of `params`.  The params' curve must already
+ * be in use.
+ */
+func newTestClientParams(t *testing.T, params ChainParams) (*Client, func()) {
+	dir, err := ioutil.TempDir("", "ozcoin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := openTestClient(dir, &params)
+
+	return c, func() {
+		c.dbm.CloseConnections()
+		os.RemoveAll(dir)
+	}
+}
+
+func openTestClient(dir string, params *ChainParams) *Client {
+	c := &Client{
+		Type:         BLOCKCHAIN_CLIENT,
+		Params:       params,
+		ChainWork:    &big.Int{},
+		TimeSource:   NewMedianTimeSource(),
+		MaxPoolSize:  MAX_POOL_SIZE,
+		PoolExpiry:   POOL_EXPIRY,
+		Fees:         NewFeeEstimator(),
+		BlockChan:    make(chan BlockSubmission),
+		TxnChan:      make(chan TxnSubmission),
+		TemplateChan: make(chan TemplateRequest),
+		Wallet:       &WalletClient{},
+	}
+	c.SetDataDir(dir)
+	c.dbm = c.OpenDatabases()
+
+	return c
+}
+
+/*
+ * Closes the databases of `c` and loads a new client from them, as if the
+ * process had restarted.  The caller closes the new client's databases.
+ */
+func restartTestClient(t *testing.T, c *Client) *Client {
+	err := c.dbm.CloseConnections()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := openTestClient(filepath.Dir(c.HeaderDBPath), c.Params)
+	err = restarted.LoadLastHeader()
+	if err != nil {
+		restarted.dbm.CloseConnections()
+		t.Fatal("Could not load chain tip after restart:", err)
+	}
+
+	return restarted
+}
+
+func TestTipSurvivesRestart(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extendTestChain(t, c, NewPrivateKey().PublicKey(), 3)
+
+	restarted := restartTestClient(t, c)
+	defer restarted.dbm.CloseConnections()
+
+	if restarted.LastHeader.Hash() != c.LastHeader.Hash() {
+		t.Error("Restarted at a different tip")
+	}
+	if restarted.LastHeader.SeqNum != 3 {
+		t.Error("Restarted at height", restarted.LastHeader.SeqNum)
+	}
+	if restarted.ChainWork.Cmp(c.ChainWork) != 0 {
+		t.Errorf("Restarted with work %v, expected %v", restarted.ChainWork, c.ChainWork)
+	}
+
+	// The restarted client keeps extending the same chain
+	extendTestChain(t, restarted, NewPrivateKey().PublicKey(), 1)
+	if restarted.LastHeader.PrevHash != c.LastHeader.Hash() {
+		t.Error("Restarted client did not extend the saved tip")
+	}
+}
+
+func TestSubscribeTip(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	miner := c.SubscribeTip()
+	stratum := c.SubscribeTip()
+
+	// Every subscriber sees the new tip, and signals coalesce to the latest
+	extendTestChain(t, c, NewPrivateKey().PublicKey(), 2)
+
+	for _, sub := range []<-chan SHA256Sum{miner, stratum} {
+		select {
+		case hash := <-sub:
+			if hash != c.LastHeader.Hash() {
+				t.Error("Subscriber was sent a stale tip")
+			}
+		default:
+			t.Fatal("Subscriber missed the new tip")
+		}
+
+		select {
+		case <-sub:
+			t.Error("Expected a single pending signal")
+		default:
+		}
+	}
+}
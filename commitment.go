@@ -1,14 +1,18 @@
 package ozcoin
 
 import (
-	"crypto/elliptic"
 	"math/big"
 )
 
-var (
-	CURVE = elliptic.P256()
-	H     = computeH()
-)
+/*
+ * `H` is the second Pedersen generator, hashed to the curve from a public
+ * domain string so nobody knows its discrete log relative to G.  Anyone who
+ * did could open a commitment to any amount.
+ */
+
+const PEDERSEN_H_DOMAIN = "ozcoin/pedersen/H"
+
+var H = ComputeH(PEDERSEN_H_DOMAIN)
 
 type Commitment struct {
 	ECCPoint
@@ -18,25 +22,28 @@ type Commitment struct {
 func RangeCommit(amt uint64, targetBlind *big.Int) Commitment {
 	rp := RangeSign(amt, targetBlind)
 
-	x, y := &big.Int{}, &big.Int{}
-	for i := uint64(0); i < RANGE_PROOF_LENGTH; i++ {
-		pk := rp.PKs[i][0]
-		x, y = CURVE.Params().Add(x, y, pk.X, pk.Y)
-	}
-
 	return Commitment{
-		ECCPoint:   ECCPoint{x, y},
+		ECCPoint:   rp.CommitSum(),
 		RangeProof: rp,
 	}
 }
 
-func computeH() ECCPoint {
-	hx, hy := CURVE.Params().ScalarBaseMult(big.NewInt(11235).Bytes())
-	if !CURVE.Params().IsOnCurve(hx, hy) {
-		panic("hx, hy is not on the curve")
+/*
+ * Verifies the range proof and that it is a proof for this commitment.  Proofs
+ * are encoded apart from their commitment, so a valid proof could otherwise be
+ * attached to a commitment to any amount, even a negative one.
+ */
+func (c Commitment) Verify() bool {
+	return c.ECCPoint.Equal(c.RangeProof.CommitSum()) && c.RangeProof.Verify()
+}
+
+func ComputeH(domain string) ECCPoint {
+	h := HashToPt([]byte(domain))
+	if !h.Valid() {
+		panic("H is not on the curve")
 	}
 
-	return ECCPoint{hx, hy}
+	return h
 }
 
 func PedersenSum(blind, amt []byte) ECCPoint {
@@ -44,12 +51,10 @@ func PedersenSum(blind, amt []byte) ECCPoint {
 }
 
 func PedersenSumPK(blind, amt []byte, pk ECCPoint) ECCPoint {
-	xGx, xGy := CURVE.Params().ScalarBaseMult(blind)
-	ePx, ePy := CURVE.Params().ScalarMult(pk.X, pk.Y, amt)
+	xG := CURVE.BaseMult(blind)
+	eP := CURVE.Mult(pk, amt)
 
-	x, y := CURVE.Params().Add(xGx, xGy, ePx, ePy)
-
-	return ECCPoint{x, y}
+	return CURVE.Add(xG, eP)
 }
 
 func PedersenDiff(blind, amt []byte) ECCPoint {
@@ -57,11 +62,8 @@ func PedersenDiff(blind, amt []byte) ECCPoint {
 }
 
 func PedersenDiffPK(blind, amt []byte, pk ECCPoint) ECCPoint {
-	xGx, xGy := CURVE.Params().ScalarBaseMult(blind)
-	ePx, ePy := CURVE.Params().ScalarMult(pk.X, pk.Y, amt)
-	ePy.Neg(ePy)
-
-	x, y := CURVE.Params().Add(xGx, xGy, ePx, ePy)
+	xG := CURVE.BaseMult(blind)
+	eP := CURVE.Mult(pk, amt).Neg()
 
-	return ECCPoint{x, y}
+	return CURVE.Add(xG, eP)
 }
@@ -27,8 +27,7 @@ func TestRangeCommit(t *testing.T) {
 		exp := PedersenSum(r.Bytes(), actualBytes)
 
 		// Subtract expected from commit
-		exp.Y.Neg(exp.Y)
-		exp.X, exp.Y = CURVE.Params().Add(rp.X, rp.Y, exp.X, exp.Y)
+		exp = CURVE.Add(rp.ECCPoint, exp.Neg())
 
 		// Should be 0's
 		zero := &big.Int{}
@@ -43,6 +42,79 @@ func TestRangeCommit(t *testing.T) {
 	}
 }
 
+func TestMismatchedCommitment(t *testing.T) {
+	commit := RangeCommit(5, RandomInt())
+	other := RangeCommit(7, RandomInt())
+
+	// A valid proof for another commitment
+	swapped := commit
+	swapped.ECCPoint = other.ECCPoint
+	if !swapped.RangeProof.Verify() {
+		t.Fatal("Range proof failed to verify")
+	}
+	if swapped.Verify() {
+		t.Error("Range proof accepted for another commitment")
+	}
+
+	// A commitment to a negative amount, 5 - 6
+	negative := commit
+	negative.ECCPoint = CURVE.Add(commit.ECCPoint, CURVE.Mult(H, UIntBytes(6)).Neg())
+	if negative.Verify() {
+		t.Error("Range proof accepted for a negative commitment")
+	}
+}
+
+func TestRangeProofForgedBit(t *testing.T) {
+	amt := uint64(1) << (RANGE_PROOF_LENGTH - 1)
+	blinds := ComputeBlinds(RandomInt())
+
+	pks := [RANGE_PROOF_LENGTH][2]ECCPoint{}
+	for i, blind := range blinds {
+		pks[i] = PKsForAmt(amt, uint64(i), blind)
+	}
+
+	// The top bit commits to 2^40 while its signer still knows the key for
+	// the bit being set
+	top := RANGE_PROOF_LENGTH - 1
+	pks[top][0] = PedersenSum(blinds[top].Bytes(), UIntBytes(1<<40))
+
+	rp := signRange(pks, amt, blinds)
+	commit := Commitment{
+		ECCPoint:   rp.CommitSum(),
+		RangeProof: rp,
+	}
+
+	if commit.Verify() {
+		t.Error("Range proof accepted a bit committing to 2^40")
+	}
+}
+
+func TestComputeH(t *testing.T) {
+	// H is the first point hashed from the domain string
+	expected := ECCPoint{
+		hexInt("7d348284fc39cc07ba5449c20edf4cb937a6cfe18a5f641f6a9067987b4d8627"),
+		hexInt("0210af091118aa8ef1351bf342a967698ac0aa63010a6fb2d3771f85e8c591e2"),
+	}
+
+	if H.X.Cmp(expected.X) != 0 || H.Y.Cmp(expected.Y) != 0 {
+		t.Fatalf("Unexpected H (%x, %x)", H.X, H.Y)
+	}
+
+	for _, params := range []*ChainParams{&MainNetParams, &TestNetParams, &RegTestParams} {
+		h := ComputeH(params.PedersenDomain)
+		if h.X.Cmp(H.X) != 0 || h.Y.Cmp(H.Y) != 0 {
+			t.Error("H does not match the domain of", params.Name)
+		}
+	}
+
+	// The old H was a known multiple of G
+	old := CURVE.BaseMult(big.NewInt(11235).Bytes())
+	g := CURVE.BaseMult([]byte{1})
+	if H.X.Cmp(old.X) == 0 || H.X.Cmp(g.X) == 0 {
+		t.Error("H has a known discrete log")
+	}
+}
+
 func BenchmarkRangeCommit(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		r := &big.Int{}
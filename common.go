@@ -7,10 +7,9 @@ import (
 )
 
 const (
-	MAX_BLOCK_SIZE     = 2 * 1024 * 1024 * 1024 // 2 MB
-	TWO_WEEKS_SEC      = 14 * 24 * 60 * 60      // 2 weeks in seconds
-	INITIAL_DIFFICULTY = 16
-	DIFFICULTY_SPACING = 2016
+	MAX_BLOCK_SIZE    = 2 * 1024 * 1024 // 2 MB of serialized block
+	MAX_BLOCK_TXNS    = 1024            // including the coinbase
+	RPC_OVERHEAD_SIZE = 64 * 1024       // rpc framing allowed on top of a block
 )
 
 func RandomBytes() SHA256Sum {
@@ -29,10 +28,24 @@ func RandomInt() *big.Int {
 
 	r := &big.Int{}
 	r.SetBytes(buf[:])
+	r.Mod(r, CURVE.Order())
 
 	return r
 }
 
+/*
+ * Returns a uniformly random index below `n`.
+ */
+func RandomIndex(n int) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		log.Println(err)
+		panic("Unable to generate random index")
+	}
+
+	return int(i.Int64())
+}
+
 func UIntBytes(x uint64) []byte {
 	i := &big.Int{}
 	i.SetUint64(x)
@@ -56,7 +56,13 @@ func (c *Client) AddOrOrphanHelper(req HashMsg) (bool, error) {
 	}
 	header := block.Header
 
-	if !ValidHeader(header) {
+	// Every chain starts from the network's genesis, which is never fetched
+	if header.SeqNum == 0 && header.Hash() != c.Params.GenesisHash {
+		log.Println("Rejecting foreign genesis block")
+		return false, nil
+	}
+
+	if !c.ValidHeader(header) {
 		return false, nil
 	}
 
@@ -67,7 +73,7 @@ func (c *Client) AddOrOrphanHelper(req HashMsg) (bool, error) {
 	prevHash := header.PrevHash
 
 	// Extending current chain
-	if prevHash == c.LastHeader.Hash() || (prevHash == SHA256Sum{}) {
+	if prevHash == c.LastHeader.Hash() {
 		return c.ExtendMainChain(header, block)
 	}
 
@@ -107,20 +113,13 @@ func (c *Client) AddOrOrphanHelper(req HashMsg) (bool, error) {
 		return false, nil
 	}
 
-	// Load previous header from main database
-	_, err = c.GetHeader(prevHash)
-	if err == nil {
+	// Parent was adopted onto the tip of the main chain
+	if prevHash == c.LastHeader.Hash() {
 		return c.ExtendMainChain(header, block)
 	}
 
-	// Load previous header from sidechain database
-	_, err = c.GetSideHeader(prevHash)
-	if err != nil {
-		return c.ExtendSideChain(header, block)
-	}
-
-	// Otherwise chain was not successfully adopted
-	panic("THIS SHOULD NEVER HAPPEN")
+	// Otherwise the parent was adopted onto a fork
+	return c.ExtendSideChain(header, block)
 }
 
 /*
@@ -138,12 +137,27 @@ func (c *Client) ExtendMainChain(header BlockHeader, block *Block) (bool, error)
 		return false, err
 	}
 
+	err = c.PutHeight(header)
+	if err != nil {
+		return false, err
+	}
+
 	// Write block if blockchain
 	err = c.WriteBlock(*block)
 	if err != nil {
 		return false, err
 	}
 
+	work, err := c.GetChainWork(header.Hash())
+	if err != nil {
+		return false, err
+	}
+
+	err = c.SetLastHeader(header, work)
+	if err != nil {
+		return false, err
+	}
+
 	if c.UpdateWallet {
 		b := *block
 		go func() {
@@ -154,8 +168,6 @@ func (c *Client) ExtendMainChain(header BlockHeader, block *Block) (bool, error)
 		}()
 	}
 
-	c.LastHeader = header
-
 	return true, nil
 }
 
@@ -164,49 +176,50 @@ func (c *Client) ExtendMainChain(header BlockHeader, block *Block) (bool, error)
  * main chain, the main chain is swapped for the side chain.
  */
 func (c *Client) ExtendSideChain(header BlockHeader, block *Block) (bool, error) {
-	// Get fork paths
-	mainPath, sidePath, err := c.FindForkPaths(header.Hash())
+	// Get fork paths leading up to the new block
+	mainPath, sidePath, err := c.FindForkPaths(header.PrevHash)
 	if err != nil {
 		return false, err
 	}
 
-	// Get difficulties of main and side chains
-	mainDiff, err := c.MainChainTotalDifficulty()
-	if err != nil {
-		return false, err
+	if !c.PostValidBlock(*block, mainPath, sidePath) {
+		return false, nil
 	}
-	sideDiff, err := c.SideChainTotalDifficulty(header.PrevHash)
+
+	// Save header and cumulative work to the side chain
+	err = c.PutSideHeader(header)
 	if err != nil {
 		return false, err
 	}
-	sideDiff += header.Difficulty
-
-	// If sidechain is smaller, validate and save blocks
-	if mainDiff > sideDiff {
-		if !c.PostValidBlock(*block, mainPath, sidePath) {
-			return false, nil
-		}
-
-		err := c.PutSideHeader(header)
-		if err != nil {
-			return false, err
-		}
 
-		// Write sidechain block if blockchain
+	// Write sidechain block if blockchain
+	if c.Type == BLOCKCHAIN_CLIENT {
 		err = c.PutSideBlock(*block)
 		if err != nil {
 			return false, err
 		}
+	}
 
+	// Compare the stored work of both chains, ties go to the main chain
+	sideWork, err := c.GetChainWork(header.Hash())
+	if err != nil {
+		return false, err
+	}
+
+	if c.ChainWork.Cmp(sideWork) >= 0 {
 		return true, nil
 	}
 
+	sidePath = append([]SHA256Sum{header.Hash()}, sidePath...)
 	err = c.SwapMainFork(mainPath, sidePath)
 	if err != nil {
 		return false, err
 	}
 
-	c.LastHeader = header
+	err = c.SetLastHeader(header, sideWork)
+	if err != nil {
+		return false, err
+	}
 
 	return true, nil
 }
@@ -217,6 +230,7 @@ func (c *Client) ExtendSideChain(header BlockHeader, block *Block) (bool, error)
 func (c *Client) SwapMainFork(mainPath, sidePath []SHA256Sum) error {
 	headerBatch := &db.Batch{}
 	sideHeaderBatch := &db.Batch{}
+	heightBatch := &db.Batch{}
 
 	// Remove main chain headers
 	for _, hash := range mainPath {
@@ -225,29 +239,44 @@ func (c *Client) SwapMainFork(mainPath, sidePath []SHA256Sum) error {
 			return err
 		}
 
-		if h == nil {
-			log.Println("Header should be in main chain")
-			panic("Header should be in main chain")
-		}
-
 		headerBatch.Delete(hash[:])
-		sideHeaderBatch.Put(hash[:], h.Json())
+		sideHeaderBatch.Put(hash[:], h.Bytes())
+		heightBatch.Delete(HeightKey(h.SeqNum))
 	}
 
-	// Add sidechain headers
+	// Add sidechain headers, after the deletions so shared heights are kept
 	for _, hash := range sidePath {
-		h, err := c.GetHeader(hash)
+		h, err := c.GetSideHeader(hash)
 		if err != nil {
 			return err
 		}
 
-		if h == nil {
-			log.Println("Header should be in side chain")
-			panic("Header should be in side chain")
+		headerBatch.Put(hash[:], h.Bytes())
+		sideHeaderBatch.Delete(hash[:])
+		heightBatch.Put(HeightKey(h.SeqNum), hash[:])
+	}
+
+	// Retrieve blocks before the headers are moved
+	deleteBlocks := []Block{}
+	for _, hash := range mainPath {
+		b, err := c.FindBlock(hash)
+		if err != nil {
+			log.Println("Block should be in main chain:", err)
+			return err
 		}
 
-		headerBatch.Put(hash[:], h.Json())
-		sideHeaderBatch.Delete(hash[:])
+		deleteBlocks = append(deleteBlocks, *b)
+	}
+
+	addBlocks := []Block{}
+	for _, hash := range sidePath {
+		b, err := c.FindBlock(hash)
+		if err != nil {
+			log.Println("Block should be in side chain:", err)
+			return err
+		}
+
+		addBlocks = append(addBlocks, *b)
 	}
 
 	// Commit batches
@@ -255,7 +284,11 @@ func (c *Client) SwapMainFork(mainPath, sidePath []SHA256Sum) error {
 	if err != nil {
 		return err
 	}
-	err = c.dbm.sideHeaderDB.Write(headerBatch, nil)
+	err = c.dbm.sideHeaderDB.Write(sideHeaderBatch, nil)
+	if err != nil {
+		return err
+	}
+	err = c.dbm.heightDB.Write(heightBatch, nil)
 	if err != nil {
 		return err
 	}
@@ -263,53 +296,29 @@ func (c *Client) SwapMainFork(mainPath, sidePath []SHA256Sum) error {
 	blockBatch := &db.Batch{}
 	sideBlockBatch := &db.Batch{}
 	pimgBatch := &db.Batch{}
-	txnPoolBatch := &db.Batch{}
 
 	// Remove main chain blocks
-	deleteBlocks := []Block{}
-	for _, hash := range mainPath {
-		// Retrieve block
-		b, err := c.GetBlock(hash)
-		if err != nil {
-			log.Println("Block should be in main chain:", err)
-			panic("Block should be in main chain")
-		}
-
-		deleteBlocks = append(deleteBlocks, *b)
-
+	for _, b := range deleteBlocks {
+		hash := b.Header.Hash()
 		blockBatch.Delete(hash.Bytes())
-		sideBlockBatch.Put(hash.Bytes(), b.Json())
+		sideBlockBatch.Put(hash.Bytes(), b.Bytes())
 
-		// Add deletions to batch
-		for _, txn := range b.Txns {
+		// Add deletions to batch, skipping the coinbase
+		for _, txn := range b.Txns[1:] {
 			pimgHash := Hash(txn.Sig.Preimage.Bytes()).Bytes()
 			pimgBatch.Delete(pimgHash)
-			// Only replace if blockchain client
-			if c.Type == BLOCKCHAIN_CLIENT {
-				txnPoolBatch.Put(pimgHash, txn.Json())
-			}
 		}
 	}
 
 	// Add side chain blocks
-	addBlocks := []Block{}
-	for _, hash := range sidePath {
-		// Retrieve block
-		b, err := c.GetBlock(hash)
-		if err != nil {
-			log.Println("Block should be in main chain:", err)
-			panic("Block should be in main chain")
-		}
-
-		addBlocks = append(addBlocks, *b)
-
-		blockBatch.Put(hash[:], b.Json())
+	for _, b := range addBlocks {
+		hash := b.Header.Hash()
+		blockBatch.Put(hash[:], b.Bytes())
 		sideBlockBatch.Delete(hash[:])
 
-		// Add deletions to batch
-		for _, txn := range b.Txns {
+		// Add preimages to batch, skipping the coinbase
+		for _, txn := range b.Txns[1:] {
 			pimgHash := Hash(txn.Sig.Preimage.Bytes()).Bytes()
-			txnPoolBatch.Delete(pimgHash)
 			pimgBatch.Put(pimgHash, pimgHash)
 		}
 	}
@@ -321,12 +330,6 @@ func (c *Client) SwapMainFork(mainPath, sidePath []SHA256Sum) error {
 		return err
 	}
 
-	log.Println("Writing txn pool")
-	err = c.dbm.txnPoolDB.Write(txnPoolBatch, nil)
-	if err != nil {
-		return err
-	}
-
 	// Commit blocks if blockchain client
 	if c.Type == BLOCKCHAIN_CLIENT {
 		err = c.dbm.blockDB.Write(blockBatch, nil)
@@ -340,31 +343,50 @@ func (c *Client) SwapMainFork(mainPath, sidePath []SHA256Sum) error {
 	}
 
 	// Teardown main fork maps
-	for _, hash := range mainPath {
-		block, err := c.FindBlock(hash)
+	for _, block := range deleteBlocks {
+		err = c.DeleteMapToBlock(block)
 		if err != nil {
 			return err
 		}
+	}
 
-		err = c.DeleteMapToBlock(*block)
+	// Build side fork maps
+	for _, block := range addBlocks {
+		err = c.PutMapToBlock(block)
 		if err != nil {
 			return err
 		}
 	}
 
-	// Build side fork maps
-	for _, hash := range sidePath {
-		block, err := c.FindBlock(hash)
-		if err != nil {
-			return err
+	// Return disconnected txns to the pool, only if blockchain client
+	if c.Type == BLOCKCHAIN_CLIENT {
+		for _, block := range deleteBlocks {
+			c.RestoreDisconnectedTxns(block)
 		}
+	}
+
+	// Forget fees from the old main fork
+	for _, block := range deleteBlocks {
+		c.Fees.DisconnectBlock(block.Header.Hash())
+	}
 
-		err = c.PutMapToBlock(*block)
+	// Record fees and remove txns confirmed by the new main fork, oldest first
+	// as if the blocks were connected one by one
+	for i := len(addBlocks) - 1; i >= 0; i-- {
+		block := addBlocks[i]
+		c.RecordConfirmedFees(block)
+		err = c.RemoveConfirmedTxns(block)
 		if err != nil {
 			return err
 		}
 	}
 
+	// Drop pool txns that spend outputs or preimages changed by the swap
+	err = c.PruneTxnPool()
+	if err != nil {
+		return err
+	}
+
 	if c.UpdateWallet {
 		go func() {
 			for _, b := range deleteBlocks {
@@ -386,59 +408,43 @@ func (c *Client) SwapMainFork(mainPath, sidePath []SHA256Sum) error {
 }
 
 /*
- * Computes the fork hash for where the side chain meets the main chain. Then
- * returns a list of hashes in each fork after the fork hash.
+ * Finds where the chain ending in `hash` meets the main chain.  Returns the
+ * main chain hashes from `LastHeader` back to the fork, and the side chain
+ * hashes from `hash` back to the fork, both excluding the fork itself.
  */
-func (c *Client) FindForkPaths(sidehash SHA256Sum) ([]SHA256Sum, []SHA256Sum, error) {
-	prevHeader, err := c.GetSideHeader(sidehash)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	prevHash := prevHeader.PrevHash
-	sideHashes := []SHA256Sum{prevHash}
+func (c *Client) FindForkPaths(hash SHA256Sum) ([]SHA256Sum, []SHA256Sum, error) {
+	// Walk side chain back to the main chain
+	sideHashes := []SHA256Sum{}
+	forkHash := hash
 	for {
-		prevHeader, err = c.GetHeader(prevHash)
-		if err != nil {
-			return nil, nil, err
-		}
-
-		if prevHeader != nil {
-			sideHashes = append(sideHashes, prevHash)
+		_, err := c.GetHeader(forkHash)
+		if err == nil {
 			break
 		}
 
-		prevHeader, err = c.GetSideHeader(prevHash)
+		header, err := c.GetSideHeader(forkHash)
 		if err != nil {
 			return nil, nil, err
 		}
 
-		if prevHeader == nil {
-			return nil, nil, nil
-		}
-
-		sideHashes = append(sideHashes, prevHash)
+		sideHashes = append(sideHashes, forkHash)
+		forkHash = header.PrevHash
 	}
 
-	forkHash := sideHashes[len(sideHashes)-1]
-	prevHash = c.LastHeader.Hash()
+	// Walk main chain back to the fork
 	mainHashes := []SHA256Sum{}
-	for {
-		prevHeader, err = c.GetHeader(prevHash)
+	prevHash := c.LastHeader.Hash()
+	for prevHash != forkHash {
+		header, err := c.GetHeader(prevHash)
 		if err != nil {
 			return nil, nil, err
 		}
 
-		if prevHeader == nil {
-			return nil, nil, nil
-		}
-
-		if prevHash == forkHash {
-			return mainHashes, sideHashes, nil
-		}
-
 		mainHashes = append(mainHashes, prevHash)
+		prevHash = header.PrevHash
 	}
+
+	return mainHashes, sideHashes, nil
 }
 
 /*
@@ -473,15 +479,30 @@ func (c *Client) AddToTxnPoolHelper(req HashMsg) (bool, error) {
 		return false, err
 	}
 
-	if !ValidTxn(*txn) && !ValidCoinbaseTxn(*txn) {
-		return false, errors.New("Invalid txn")
+	err = c.AdmitTxn(*txn)
+	if err != nil {
+		return false, errors.New("Txn rejected: " + err.Error())
 	}
 
-	err = c.PutTxnPool(*txn)
+	return true, nil
+
+}
+
+/*
+ * Fully validates a txn, including its ring signature, range proofs, inputs
+ * and preimage, before adding it to the txn pool.  The returned error gives
+ * the reason for rejection.
+ */
+func (c *Client) AdmitTxn(txn Txn) error {
+	err := c.validTxn(txn)
 	if err != nil {
-		return false, err
+		return err
 	}
 
-	return true, nil
+	err = c.verifyTxn(txn, c.LastHeader.SeqNum+1, nil, nil, nil, nil, nil)
+	if err != nil {
+		return err
+	}
 
+	return c.PutTxnPool(txn)
 }
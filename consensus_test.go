@@ -0,0 +1,467 @@
+package ozcoin
+
+import (
+	"math/big"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+/*
+ * Builds and solves a block on top of `prev` paying `addr` and including only
+ * `txns`.
+ */
+func mineTestBlock(c *Client, prev BlockHeader, addr WalletPublicKey, txns []Txn) Block {
+	template := c.NewBlockTemplate(prev)
+	template.Txns = txns
+	template.CoinbaseValue = c.Params.CoinbaseValue(template.SeqNum)
+	for _, txn := range txns {
+		template.CoinbaseValue += txn.Body.Fee
+	}
+
+	block := template.Block(addr)
+	for !block.Header.ValidPoW() {
+		block.Header.Nonce++
+	}
+
+	return block
+}
+
+/*
+ * Spends the first of `inputs`, a coinbase output paying `value` to `priv`,
+ * back to `priv` with one unit of change.
+ */
+func spendTestCoinbase(c *Client, priv WalletPrivateKey, inputs []Output, value, fee uint64) Txn {
+	sk := inputs[0].ComputeTxnPrivateKey(priv)
+	yi := inputs[0].ComputeBlindingFactor(priv)
+	amts := []uint64{value - fee - 1, 1}
+	rcpts := []WalletPublicKey{priv.PublicKey(), priv.PublicKey()}
+
+	return *c.NewTxn(inputs, sk, yi, 0, amts, rcpts, fee)
+}
+
+/*
+ * Signs a txn spending the first of `inputs` into `outputs`, which may have
+ * been tampered with after `BuildOutputs` returned them with `blindSum`.
+ */
+func signTestOutputs(priv WalletPrivateKey, inputs, outputs []Output, blindSum *big.Int, fee uint64) Txn {
+	pks, ics, hashes := []ECCPoint{}, []ECCPoint{}, []SHA256Sum{}
+	for _, inp := range inputs {
+		pks = append(pks, inp.DestKey)
+		ics = append(ics, inp.Commit.ECCPoint)
+		hashes = append(hashes, inp.Hash())
+	}
+
+	txn := Txn{
+		Body: TxnBody{
+			Inputs:  hashes,
+			Outputs: outputs,
+			Fee:     fee,
+		},
+	}
+
+	sk := inputs[0].ComputeTxnPrivateKey(priv)
+	yi := inputs[0].ComputeBlindingFactor(priv)
+	txn.OZRSSign(pks, ics, sk, yi, 0, blindSum, HASH_TO_PT_TRY_INCREMENT)
+
+	return txn
+}
+
+/*
+ * A peer recording the txn hashes broadcast to it.
+ */
+type testBcastPeer struct {
+	hashes chan SHA256Sum
+}
+
+func (p *testBcastPeer) BcastTxnRPC(req HashMsg, res *RPCHeader) error {
+	p.hashes <- req.Hash
+	return nil
+}
+
+/*
+ * Listens as a peer of `c`, returning the txn hashes it is sent.
+ */
+func listenTestBcastPeer(t *testing.T, c *Client) (<-chan SHA256Sum, func()) {
+	peer := &testBcastPeer{make(chan SHA256Sum, 16)}
+
+	server := rpc.NewServer()
+	err := server.RegisterName("GossipCore", peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(l)
+
+	err = c.PutPeer(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return peer.hashes, func() { l.Close() }
+}
+
+/*
+ * Extends the main chain by `n` blocks paying `addr`, returning their coinbase
+ * outputs.
+ */
+func extendTestChain(t *testing.T, c *Client, addr WalletPublicKey, n int) []Output {
+	outputs := []Output{}
+	for i := 0; i < n; i++ {
+		block := mineTestBlock(c, c.LastHeader, addr, nil)
+		success, err := c.ExtendMainChain(block.Header, &block)
+		if !success || err != nil {
+			t.Fatal("Could not extend main chain:", err)
+		}
+
+		outputs = append(outputs, block.Txns[0].Body.Outputs[0])
+	}
+
+	return outputs
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	priv := NewPrivateKey()
+	addr := priv.PublicKey()
+	inputs := extendTestChain(t, c, addr, c.Params.TxnNumInputs)
+
+	// Stop one block short of the newest decoy maturing
+	extendTestChain(t, c, addr, int(c.Params.CoinbaseMaturity)-2)
+
+	value := c.Params.CoinbaseValue(1)
+	txn := spendTestCoinbase(c, *priv, inputs, value, 10)
+	err = c.AdmitTxn(txn)
+	if err == nil {
+		t.Fatal("Txn with immature decoy accepted")
+	}
+
+	extendTestChain(t, c, addr, 1)
+	err = c.AdmitTxn(txn)
+	if err != nil {
+		t.Fatal("Txn with mature inputs rejected:", err)
+	}
+}
+
+func TestReorgTxnPool(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	priv := NewPrivateKey()
+	addr := priv.PublicKey()
+
+	// Fund and mature enough coinbase outputs to fill a ring
+	inputs := extendTestChain(t, c, addr, c.Params.TxnNumInputs)
+	extendTestChain(t, c, addr, int(c.Params.CoinbaseMaturity))
+	fork := c.LastHeader
+
+	value := c.Params.CoinbaseValue(1)
+	txn := spendTestCoinbase(c, *priv, inputs, value, 10)
+	err = c.AdmitTxn(txn)
+	if err != nil {
+		t.Fatal("Txn rejected:", err)
+	}
+
+	// Confirming the txn removes it from the pool
+	confirmed := mineTestBlock(c, fork, addr, []Txn{txn})
+	success, err := c.ExtendMainChain(confirmed.Header, &confirmed)
+	if !success || err != nil {
+		t.Fatal("Could not extend main chain:", err)
+	}
+
+	if c.PoolInfo().Count != 0 {
+		t.Error("Confirmed txn left in pool")
+	}
+
+	// Gossip of the confirmed txn is filtered and served by txn hash
+	err = c.FilterTxn(c.NewHashMsg(txn.Hash()))
+	if err != nil {
+		t.Error("Confirmed txn not filtered:", err)
+	}
+
+	loaded, err := c.LoadTxn(txn.Hash())
+	if err != nil || loaded.Hash() != txn.Hash() {
+		t.Error("Confirmed txn not loaded by hash:", err)
+	}
+
+	// A heavier fork without the txn returns it to the pool
+	prev := fork
+	for i := 0; i < 2; i++ {
+		block := mineTestBlock(c, prev, addr, nil)
+		success, err := c.ExtendSideChain(block.Header, &block)
+		if !success || err != nil {
+			t.Fatal("Could not extend side chain:", err)
+		}
+
+		prev = block.Header
+	}
+
+	if c.LastHeader.Hash() != prev.Hash() {
+		t.Fatal("Heavier fork not adopted")
+	}
+
+	_, err = c.GetTxnPool(txn.Hash())
+	if err != nil {
+		t.Error("Disconnected txn not restored to pool")
+	}
+
+	indexed, err := c.GetPoolPreimage(Hash(txn.Sig.Preimage.Bytes()))
+	if err != nil || indexed != txn.Hash() {
+		t.Error("Restored txn not indexed by preimage")
+	}
+
+	// Reorganizing back onto the txn's fork confirms it again
+	prev = confirmed.Header
+	for i := 0; i < 2; i++ {
+		block := mineTestBlock(c, prev, addr, nil)
+		success, err := c.ExtendSideChain(block.Header, &block)
+		if !success || err != nil {
+			t.Fatal("Could not extend side chain:", err)
+		}
+
+		prev = block.Header
+	}
+
+	if c.LastHeader.Hash() != prev.Hash() {
+		t.Fatal("Original fork not readopted")
+	}
+
+	if c.PoolInfo().Count != 0 {
+		t.Error("Reconfirmed txn left in pool")
+	}
+}
+
+func TestHeavierShorterForkWins(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	// Retarget every 4 blocks, aiming for one block every 10 seconds
+	c.Params.NoRetargeting = false
+	c.Params.RetargetInterval = 4
+	c.Params.TargetTimespan = 40
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := c.LastHeader
+	addr := NewPrivateKey().PublicKey()
+
+	// Blocks mined long after genesis keep the main chain at the limit
+	extendTestChain(t, c, addr, 5)
+	mainTip := c.LastHeader
+	if mainTip.Bits != c.Params.PowLimitBits {
+		t.Fatal("Main chain retargeted away from the limit")
+	}
+
+	// Blocks a second apart make the fork's fourth block 4x harder
+	prev := genesis
+	for i := 1; i <= 4; i++ {
+		block := c.NewBlockTemplate(prev).Block(addr)
+		block.Header.Time = genesis.Time.Add(time.Duration(i) * time.Second)
+		for !block.Header.ValidPoW() {
+			block.Header.Nonce++
+		}
+
+		if !c.ValidDifficulty(block) {
+			t.Fatal("Fork block has invalid difficulty")
+		}
+
+		success, err := c.ExtendSideChain(block.Header, &block)
+		if !success || err != nil {
+			t.Fatal("Could not extend side chain:", err)
+		}
+
+		prev = block.Header
+	}
+
+	if prev.Bits == c.Params.PowLimitBits {
+		t.Fatal("Fork did not retarget")
+	}
+	if prev.SeqNum >= mainTip.SeqNum {
+		t.Fatal("Fork is not shorter than the main chain")
+	}
+
+	if c.LastHeader.Hash() != prev.Hash() {
+		t.Fatal("Heavier, shorter fork not adopted")
+	}
+
+	work, err := c.GetChainWork(prev.Hash())
+	if err != nil || c.ChainWork.Cmp(work) != 0 {
+		t.Error("Chain work does not match the fork's tip")
+	}
+
+	mainWork, err := c.GetChainWork(mainTip.Hash())
+	if err != nil || mainWork.Cmp(c.ChainWork) >= 0 {
+		t.Error("Former main chain not lighter than the fork")
+	}
+
+	header, err := c.HeaderAtHeight(mainTip.SeqNum)
+	if err == nil && header != nil {
+		t.Error("Height of the former tip still indexed")
+	}
+}
+
+func TestPutChainWorkMissingParent(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := mineTestBlock(c, c.LastHeader, NewPrivateKey().PublicKey(), nil)
+	block.Header.PrevHash = SHA256Sum{1}
+
+	err = c.PutSideHeader(block.Header)
+	if err == nil {
+		t.Error("Side header accepted without its parent's work")
+	}
+
+	// Orphans count only their own work
+	err = c.PutOrphanHeader(block.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	work, err := c.GetChainWork(block.Header.Hash())
+	if err != nil || work.Cmp(block.Header.Work()) != 0 {
+		t.Error("Orphan work not recorded")
+	}
+}
+
+func TestSubmitInvalidTxns(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	priv := NewPrivateKey()
+	addr := priv.PublicKey()
+	inputs := extendTestChain(t, c, addr, c.Params.TxnNumInputs)
+	extendTestChain(t, c, addr, int(c.Params.CoinbaseMaturity))
+
+	bcasts, closePeer := listenTestBcastPeer(t, c)
+	defer closePeer()
+
+	go c.run()
+
+	value := c.Params.CoinbaseValue(1)
+	valid := spendTestCoinbase(c, *priv, inputs, value, 10)
+
+	// Range proofs are covered by the signature, so re-sign a bad one
+	outputs, blindSum := BuildOutputs([]uint64{value - 11, 1},
+		[]WalletPublicKey{addr, addr})
+	outputs[0].Commit.RangeProof.Ss[0][0] = RandomScalar()
+	badRangeProof := signTestOutputs(*priv, inputs, outputs, blindSum, 10)
+
+	// Swapping commitments keeps their sum, and so the signature, valid
+	outputs, blindSum = BuildOutputs([]uint64{value - 11, 1},
+		[]WalletPublicKey{addr, addr})
+	outputs[0].Commit.ECCPoint, outputs[1].Commit.ECCPoint =
+		outputs[1].Commit.ECCPoint, outputs[0].Commit.ECCPoint
+	swappedCommits := signTestOutputs(*priv, inputs, outputs, blindSum, 10)
+
+	tests := []struct {
+		name   string
+		tamper func(*Txn)
+		reason string
+	}{
+		{"signature challenge", func(txn *Txn) {
+			txn.Sig.E[0] ^= 1
+		}, "Invalid ring signature"},
+		{"ring member r", func(txn *Txn) {
+			txn.Sig.Rs = append([]Scalar{}, txn.Sig.Rs...)
+			txn.Sig.Rs[1] = RandomScalar()
+		}, "Invalid ring signature"},
+		{"ring member s", func(txn *Txn) {
+			txn.Sig.Ss = append([]Scalar{}, txn.Sig.Ss...)
+			txn.Sig.Ss[0] = RandomScalar()
+		}, "Invalid ring signature"},
+		{"range proof", func(txn *Txn) {
+			*txn = badRangeProof
+		}, "Invalid range proof"},
+		{"commitments", func(txn *Txn) {
+			*txn = swappedCommits
+		}, "Invalid range proof"},
+		{"missing input", func(txn *Txn) {
+			txn.Body.Inputs = append([]SHA256Sum{}, txn.Body.Inputs...)
+			txn.Body.Inputs[1] = SHA256Sum{1}
+		}, "Could not load txn input"},
+	}
+
+	for _, test := range tests {
+		txn := valid
+		test.tamper(&txn)
+
+		err = c.SubmitTxn(txn)
+		if err == nil || err.Error() != test.reason {
+			t.Errorf("Tampered %s: expected %q, got %v", test.name, test.reason, err)
+		}
+	}
+
+	if c.PoolInfo().Count != 0 {
+		t.Fatal("Rejected txns added to pool")
+	}
+
+	// Only the valid txn is pooled and broadcast
+	err = c.SubmitTxn(valid)
+	if err != nil {
+		t.Fatal("Valid txn rejected:", err)
+	}
+	if c.PoolInfo().Count != 1 {
+		t.Error("Valid txn not added to pool")
+	}
+
+	select {
+	case hash := <-bcasts:
+		if hash != valid.Hash() {
+			t.Error("Rejected txn broadcast")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Valid txn not broadcast")
+	}
+
+	// Once confirmed, another spend of the same input shares its preimage
+	block := mineTestBlock(c, c.LastHeader, addr, []Txn{valid})
+	err = c.SubmitBlock(block)
+	if err != nil {
+		t.Fatal("Could not confirm txn:", err)
+	}
+
+	spent := spendTestCoinbase(c, *priv, inputs, value, 20)
+	err = c.SubmitTxn(spent)
+	if err == nil || err.Error() != "Txn preimage already spent" {
+		t.Error("Double spend: expected preimage already spent, got", err)
+	}
+	if c.PoolInfo().Count != 0 {
+		t.Error("Double spend added to pool")
+	}
+
+	select {
+	case <-bcasts:
+		t.Error("Rejected txn broadcast")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,225 @@
+package ozcoin
+
+import (
+	"crypto/elliptic"
+	"encoding/binary"
+	"errors"
+	"math/big"
+)
+
+/*
+ * Curve
+ *
+ * The group that keys, commitments and signatures live in.  Points are affine
+ * `ECCPoint`s with the point at infinity as (0, 0), and scalars are big-endian
+ * bytes.  Each network picks a backend through its `Curve` param, and
+ * `UseParams` makes it the process wide `CURVE`.
+ */
+type Curve interface {
+	Name() string
+	Order() *big.Int
+
+	BaseMult(k []byte) ECCPoint
+	Mult(p ECCPoint, k []byte) ECCPoint
+	Add(a, b ECCPoint) ECCPoint
+	Neg(p ECCPoint) ECCPoint
+	IsOnCurve(p ECCPoint) bool
+
+	// 33 byte compressed SEC1, the point at infinity is 33 zero bytes
+	Encode(p ECCPoint) []byte
+	Decode(b []byte) (ECCPoint, error)
+
+	// Try-and-increment, the result has no known discrete log
+	HashToPt(data []byte) ECCPoint
+}
+
+var CURVE Curve = P256Curve
+
+/*
+ * Short Weierstrass curve y^2 = x^3 + ax + b over a 256 bit prime field.  It
+ * implements everything but the group law, which is left to each backend.
+ */
+type weierstrass struct {
+	name      string
+	p, n      *big.Int
+	a, b      *big.Int
+	gx, gy    *big.Int
+	h2pDomain string
+}
+
+func (c *weierstrass) Name() string {
+	return c.name
+}
+
+func (c *weierstrass) Order() *big.Int {
+	return c.n
+}
+
+/*
+ * Computes x^3 + ax + b mod p.
+ */
+func (c *weierstrass) rhs(x *big.Int) *big.Int {
+	r := &big.Int{}
+	r.Mul(x, x)
+	r.Add(r, c.a)
+	r.Mul(r, x)
+	r.Add(r, c.b)
+	r.Mod(r, c.p)
+
+	return r
+}
+
+func (c *weierstrass) inField(i *big.Int) bool {
+	return i.Sign() >= 0 && i.Cmp(c.p) < 0
+}
+
+func (c *weierstrass) IsOnCurve(p ECCPoint) bool {
+	if p.Empty() || !c.inField(p.X) || !c.inField(p.Y) {
+		return false
+	}
+
+	y2 := &big.Int{}
+	y2.Mul(p.Y, p.Y)
+	y2.Mod(y2, c.p)
+
+	return y2.Cmp(c.rhs(p.X)) == 0
+}
+
+func (c *weierstrass) Neg(p ECCPoint) ECCPoint {
+	if p.Empty() || p.IsIdentity() {
+		return ECCPoint{&big.Int{}, &big.Int{}}
+	}
+
+	y := &big.Int{}
+	y.Mod(p.Y, c.p)
+	y.Sub(c.p, y)
+
+	return ECCPoint{new(big.Int).Set(p.X), y}
+}
+
+func (c *weierstrass) Encode(p ECCPoint) []byte {
+	b := make([]byte, POINT_LENGTH)
+	if p.Empty() || p.IsIdentity() {
+		return b
+	}
+
+	x := &big.Int{}
+	x.Mod(p.X, c.p)
+	y := &big.Int{}
+	y.Mod(p.Y, c.p)
+
+	b[0] = POINT_EVEN_PREFIX | uint8(y.Bit(0))
+	x.FillBytes(b[1:])
+
+	return b
+}
+
+func (c *weierstrass) Decode(b []byte) (ECCPoint, error) {
+	if len(b) != POINT_LENGTH {
+		return ECCPoint{}, errors.New("Invalid point length")
+	}
+
+	x := &big.Int{}
+	x.SetBytes(b[1:])
+
+	switch b[0] {
+	case 0:
+		if x.Sign() != 0 {
+			return ECCPoint{}, errors.New("Invalid point encoding")
+		}
+		return ECCPoint{&big.Int{}, &big.Int{}}, nil
+
+	case POINT_EVEN_PREFIX, POINT_ODD_PREFIX:
+		if !c.inField(x) {
+			return ECCPoint{}, errors.New("Point out of range")
+		}
+
+	default:
+		return ECCPoint{}, errors.New("Invalid point encoding")
+	}
+
+	y := &big.Int{}
+	if y.ModSqrt(c.rhs(x), c.p) == nil {
+		return ECCPoint{}, errors.New("Point not on curve")
+	}
+
+	if uint8(y.Bit(0)) != b[0]&1 {
+		y.Sub(c.p, y)
+	}
+
+	return ECCPoint{x, y}, nil
+}
+
+/*
+ * Each attempt hashes the domain, a 4 byte counter and the data into an X
+ * coordinate, and the first X on the curve is taken with even Y.  Both
+ * backends have a cofactor of 1, so every such point is in the group.
+ */
+func (c *weierstrass) HashToPt(data []byte) ECCPoint {
+	b := make([]byte, POINT_LENGTH)
+	b[0] = POINT_EVEN_PREFIX
+
+	ctrBytes := make([]byte, 4)
+	for ctr := uint32(0); ; ctr++ {
+		binary.BigEndian.PutUint32(ctrBytes, ctr)
+
+		msg := []byte(c.h2pDomain)
+		msg = append(msg, ctrBytes...)
+		msg = append(msg, data...)
+
+		h := Hash(msg)
+		copy(b[1:], h[:])
+
+		p, err := c.Decode(b)
+		if err == nil && !p.IsIdentity() {
+			return p
+		}
+	}
+}
+
+/*
+ * P-256 backed by crypto/elliptic, whose group law is constant time.
+ */
+type p256Curve struct {
+	weierstrass
+	curve elliptic.Curve
+}
+
+var P256Curve Curve = newP256Curve()
+
+func newP256Curve() *p256Curve {
+	params := elliptic.P256().Params()
+
+	return &p256Curve{
+		weierstrass: weierstrass{
+			name:      "P256",
+			p:         params.P,
+			n:         params.N,
+			a:         big.NewInt(-3),
+			b:         params.B,
+			gx:        params.Gx,
+			gy:        params.Gy,
+			h2pDomain: "ozcoin/hash-to-point/P256/try-and-increment",
+		},
+		curve: elliptic.P256(),
+	}
+}
+
+func (c *p256Curve) BaseMult(k []byte) ECCPoint {
+	x, y := c.curve.ScalarBaseMult(k)
+	return ECCPoint{x, y}
+}
+
+func (c *p256Curve) Mult(p ECCPoint, k []byte) ECCPoint {
+	if p.IsIdentity() {
+		return ECCPoint{&big.Int{}, &big.Int{}}
+	}
+
+	x, y := c.curve.ScalarMult(p.X, p.Y, k)
+	return ECCPoint{x, y}
+}
+
+func (c *p256Curve) Add(a, b ECCPoint) ECCPoint {
+	x, y := c.curve.Add(a.X, a.Y, b.X, b.Y)
+	return ECCPoint{x, y}
+}
@@ -0,0 +1,299 @@
+package ozcoin
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+/*
+ * Runs `f` with the network's curve selected, restoring regtest afterwards.
+ */
+func withParams(t *testing.T, params *ChainParams, f func()) {
+	err := UseParams(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer UseParams(&RegTestParams)
+
+	f()
+}
+
+func TestSecp256k1Vectors(t *testing.T) {
+	curve := Secp256k1Curve
+	n := curve.Order()
+
+	vectors := []struct {
+		k        *big.Int
+		expected string
+	}{
+		{big.NewInt(1), "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		{big.NewInt(2), "02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5"},
+		{big.NewInt(3), "02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9"},
+		{new(big.Int).Sub(n, big.NewInt(1)), "0379be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+	}
+
+	for _, v := range vectors {
+		p := curve.BaseMult(v.k.Bytes())
+		if !curve.IsOnCurve(p) {
+			t.Fatal("Point not on curve")
+		}
+
+		encoded := hex.EncodeToString(curve.Encode(p))
+		if encoded != v.expected {
+			t.Errorf("%d*G = %s, expected %s", v.k, encoded, v.expected)
+		}
+
+		decoded, err := curve.Decode(curve.Encode(p))
+		if err != nil || decoded.X.Cmp(p.X) != 0 || decoded.Y.Cmp(p.Y) != 0 {
+			t.Error("Point changed in round trip")
+		}
+	}
+
+	// G + G = 2G, and G + -G is the point at infinity
+	g := curve.BaseMult([]byte{1})
+	if hex.EncodeToString(curve.Encode(curve.Add(g, g))) != vectors[1].expected {
+		t.Error("G + G is not 2G")
+	}
+
+	if !curve.Add(g, curve.Neg(g)).IsIdentity() {
+		t.Error("G - G is not the point at infinity")
+	}
+
+	if !curve.BaseMult(n.Bytes()).IsIdentity() {
+		t.Error("N*G is not the point at infinity")
+	}
+
+	h := curve.HashToPt([]byte("abc"))
+	if h.X.Cmp(hexInt("b053e153e82894a5043cfd9340db2ded9723788afa82ad3b016f4b5f1f88d496")) != 0 ||
+		h.Y.Cmp(hexInt("8d457de67effcd75e7601e29d0fe94bf9b9021534d6fb409e5b0beb166f6b0fe")) != 0 {
+		t.Errorf("Unexpected secp256k1 HashToPt (%x, %x)", h.X, h.Y)
+	}
+}
+
+/*
+ * Both backends must agree on the group law for the same scalars.
+ */
+func TestCurveGroupLaw(t *testing.T) {
+	for _, curve := range []Curve{P256Curve, Secp256k1Curve} {
+		a, b := RandomInt(), RandomInt()
+		sum := &big.Int{}
+		sum.Add(a, b)
+
+		aG := curve.BaseMult(a.Bytes())
+		bG := curve.BaseMult(b.Bytes())
+		expected := curve.BaseMult(sum.Bytes())
+
+		actual := curve.Add(aG, bG)
+		if actual.X.Cmp(expected.X) != 0 || actual.Y.Cmp(expected.Y) != 0 {
+			t.Error("aG + bG != (a+b)G on", curve.Name())
+		}
+
+		ab := &big.Int{}
+		ab.Mul(a, b)
+		abG := curve.Mult(aG, b.Bytes())
+		ab.Mod(ab, curve.Order())
+		expected = curve.BaseMult(ab.Bytes())
+		if abG.X.Cmp(expected.X) != 0 || abG.Y.Cmp(expected.Y) != 0 {
+			t.Error("b(aG) != (ab)G on", curve.Name())
+		}
+	}
+}
+
+func TestSecp256k1Signatures(t *testing.T) {
+	withParams(t, &RegTestSecp256k1Params, func() {
+		if !H.Valid() {
+			t.Fatal("H not on secp256k1")
+		}
+
+		// Commitments and range proofs
+		blind := RandomInt()
+		commit := RangeCommit(1234, blind)
+		if !commit.Verify() {
+			t.Error("Range proof failed to verify on secp256k1")
+		}
+
+		// OZRS over a full txn, which must survive the binary encoding
+		amts := []uint64{1, 4999999998}
+		rcpts := []WalletPublicKey{
+			NewPrivateKey().PublicKey(),
+			NewPrivateKey().PublicKey(),
+		}
+
+		pks, sec := pksAndSecret()
+		ics, yi := commitmentsAndBF(5000000000)
+		outputs, bf := BuildOutputs(amts, rcpts)
+
+		txn := Txn{
+			Body: TxnBody{
+				Inputs:  make([]SHA256Sum, len(pks)),
+				Outputs: outputs,
+				Fee:     1,
+			},
+		}
+		txn.OZRSSign(pks, ics, sec, yi, 0, bf, HASH_TO_PT_TRY_INCREMENT)
+
+		decoded := Txn{}
+		err := decoded.UnmarshalBinary(txn.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !decoded.VerifyOZRS(pks, ics, HASH_TO_PT_TRY_INCREMENT) {
+			t.Error("OZRS failed to verify on secp256k1")
+		}
+
+		for _, output := range decoded.Body.Outputs {
+			if !output.Commit.RangeProof.Verify() {
+				t.Error("Output range proof failed to verify on secp256k1")
+			}
+		}
+
+		// Points from one curve are rejected by the other
+		p256Point := P256Curve.BaseMult(RandomInt().Bytes())
+		if p256Point.Valid() {
+			t.Error("P256 point accepted on secp256k1")
+		}
+	})
+
+	if !H.Valid() || CURVE != P256Curve {
+		t.Error("Regtest curve not restored")
+	}
+}
+
+/*
+ * Reference double-and-add built only on `Add`.
+ */
+func testMult(curve Curve, p ECCPoint, k *big.Int) ECCPoint {
+	acc := ECCPoint{&big.Int{}, &big.Int{}}
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		acc = curve.Add(acc, acc)
+		if k.Bit(i) == 1 {
+			acc = curve.Add(acc, p)
+		}
+	}
+
+	return acc
+}
+
+func TestSecp256k1MultScalars(t *testing.T) {
+	curve := Secp256k1Curve
+	n := curve.Order()
+	p := curve.HashToPt([]byte("mult"))
+
+	scalars := []*big.Int{
+		big.NewInt(0),
+		big.NewInt(1),
+		big.NewInt(2),
+		new(big.Int).Sub(n, big.NewInt(1)),
+		new(big.Int).Set(n),
+		new(big.Int).Add(n, big.NewInt(1)),
+		new(big.Int).Lsh(n, 8),
+		RandomInt(),
+	}
+
+	for _, k := range scalars {
+		expected := testMult(curve, p, new(big.Int).Mod(k, n))
+		actual := curve.Mult(p, k.Bytes())
+		if !curve.IsOnCurve(actual) && !actual.IsIdentity() {
+			t.Fatalf("%x*P not on curve", k)
+		}
+
+		if actual.X.Cmp(expected.X) != 0 || actual.Y.Cmp(expected.Y) != 0 {
+			t.Errorf("%x*P = (%x, %x), expected (%x, %x)", k, actual.X, actual.Y, expected.X, expected.Y)
+		}
+	}
+
+	if !curve.Mult(ECCPoint{&big.Int{}, &big.Int{}}, []byte{5}).IsIdentity() {
+		t.Error("Multiple of the point at infinity is not the point at infinity")
+	}
+}
+
+func TestUseParamsRejectsOtherCurve(t *testing.T) {
+	defer UseParams(&RegTestParams)
+
+	// Genesis keys must be on the network's curve
+	foreign := RegTestParams
+	foreign.Curve = Secp256k1Curve
+	err := UseParams(&foreign)
+	if err == nil {
+		t.Error("Accepted P256 genesis keys on secp256k1")
+	}
+	if CURVE != P256Curve {
+		t.Fatal("Rejected params changed the curve")
+	}
+
+	err = bindParams(&RegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { paramsBound = false }()
+
+	// A bound process keeps its curve and domain
+	err = UseParams(&RegTestSecp256k1Params)
+	if err == nil {
+		t.Error("Switched curves under a running client")
+	}
+
+	domain := RegTestParams
+	domain.PedersenDomain = "ozcoin/pedersen/other"
+	err = UseParams(&domain)
+	if err == nil {
+		t.Error("Switched Pedersen domain under a running client")
+	}
+
+	if CURVE != P256Curve || !H.Valid() {
+		t.Fatal("Bound curve changed")
+	}
+
+	// The same network may be used again
+	err = UseParams(&RegTestParams)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSecp256k1Chain(t *testing.T) {
+	withParams(t, &RegTestSecp256k1Params, func() {
+		c, cleanup := newTestClientParams(t, RegTestSecp256k1Params)
+		defer cleanup()
+
+		err := c.InitGenesis()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		priv := NewPrivateKey()
+		addr := priv.PublicKey()
+		inputs := extendTestChain(t, c, addr, c.Params.TxnNumInputs)
+		extendTestChain(t, c, addr, int(c.Params.CoinbaseMaturity))
+
+		// A signed txn is admitted and mined through the run loop
+		value := c.Params.CoinbaseValue(1)
+		txn := spendTestCoinbase(c, *priv, inputs, value, 10)
+		err = c.AdmitTxn(txn)
+		if err != nil {
+			t.Fatal("Txn rejected on secp256k1:", err)
+		}
+
+		go c.run()
+
+		m := &Miner{Client: c, threads: 2}
+		hashes, err := m.Generate(1, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		block, err := c.LoadBlock(hashes[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(block.Txns) != 2 || block.Txns[1].Hash() != txn.Hash() {
+			t.Error("Mined block does not confirm the txn")
+		}
+		if c.LastHeader.Hash() != hashes[0] {
+			t.Error("Mined block not adopted")
+		}
+	})
+}
@@ -3,11 +3,15 @@ package ozcoin
 import (
 	db "github.com/syndtr/goleveldb/leveldb"
 
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"log"
+	"math/big"
 )
 
+var TIP_KEY = []byte("tip")
+
 type DBManager struct {
 	headerDB       *db.DB
 	sideHeaderDB   *db.DB
@@ -19,6 +23,11 @@ type DBManager struct {
 	pimgDB         *db.DB
 	peerDB         *db.DB
 	txnPoolDB      *db.DB
+	poolPimgDB     *db.DB
+	heightDB       *db.DB
+	workDB         *db.DB
+	feeDB          *db.DB
+	poolIndex      *poolIndex
 }
 
 func (c *Client) OpenDatabases() *DBManager {
@@ -39,6 +48,44 @@ func (dbm *DBManager) OpenConnections(c *Client) {
 	dbm.pimgDB = c.OpenPreimageDB()
 	dbm.peerDB = c.OpenPeerDB()
 	dbm.txnPoolDB = c.OpenTxnPoolDB()
+	dbm.poolIndex = loadPoolIndex(dbm.txnPoolDB)
+	dbm.poolPimgDB = c.OpenPoolPreimageDB()
+	dbm.heightDB = c.OpenHeightDB()
+	dbm.workDB = c.OpenWorkDB()
+	dbm.feeDB = c.OpenFeeDB()
+}
+
+/*
+ * Closes every database, returning the first error encountered.  The manager
+ * can not be used afterwards.
+ */
+func (dbm *DBManager) CloseConnections() error {
+	dbs := []*db.DB{
+		dbm.headerDB,
+		dbm.sideHeaderDB,
+		dbm.orphanHeaderDB,
+		dbm.blockDB,
+		dbm.sideBlockDB,
+		dbm.orphanBlockDB,
+		dbm.mapDB,
+		dbm.pimgDB,
+		dbm.peerDB,
+		dbm.txnPoolDB,
+		dbm.poolPimgDB,
+		dbm.heightDB,
+		dbm.workDB,
+		dbm.feeDB,
+	}
+
+	var closeErr error
+	for _, d := range dbs {
+		err := d.Close()
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+
+	return closeErr
 }
 
 /*
@@ -66,7 +113,7 @@ func (c *Client) GetHeader(hash SHA256Sum) (*BlockHeader, error) {
 	}
 
 	header := &BlockHeader{}
-	err = json.Unmarshal(headerBytes, header)
+	err = header.UnmarshalBinary(headerBytes)
 	if err != nil {
 		return nil, err
 	}
@@ -78,16 +125,201 @@ func (c *Client) ExtendHeader(header BlockHeader) error {
 	hash := header.Hash()
 
 	batch := &db.Batch{}
-	batch.Put(hash[:], header.Json())
+	batch.Put(hash[:], header.Bytes())
 	batch.Delete(header.PrevHash[:])
 
 	return c.dbm.headerDB.Write(batch, nil)
 }
 
 func (c *Client) PutHeader(header BlockHeader) error {
+	err := c.PutChainWork(header)
+	if err != nil {
+		return err
+	}
+
 	hash := header.Hash()
 	log.Println("Putting header")
-	return c.dbm.headerDB.Put(hash[:], header.Json(), nil)
+	return c.dbm.headerDB.Put(hash[:], header.Bytes(), nil)
+}
+
+/*
+ * Chain Tip
+ *
+ * Stored in the main header database under `TIP_KEY` so the best chain
+ * survives restarts.
+ */
+
+type ChainTip struct {
+	Hash SHA256Sum `json:"hash"`
+	Work *big.Int  `json:"work"`
+}
+
+func (c *Client) GetTip() (*ChainTip, error) {
+	tipBytes, err := c.dbm.headerDB.Get(TIP_KEY, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tip := &ChainTip{}
+	err = json.Unmarshal(tipBytes, tip)
+	if err != nil {
+		return nil, err
+	}
+
+	return tip, nil
+}
+
+func (c *Client) PutTip(tip ChainTip) error {
+	tipBytes, err := json.Marshal(tip)
+	if err != nil {
+		return err
+	}
+
+	return c.dbm.headerDB.Put(TIP_KEY, tipBytes, nil)
+}
+
+/*
+ * Height Index Database
+ *
+ * Maps the SeqNum of every main chain block to its hash.
+ */
+
+func (c *Client) OpenHeightDB() *db.DB {
+	heightDB, err := db.OpenFile(c.HeightDBPath, nil)
+	if err != nil {
+		log.Println(err)
+		panic("Unable to open height database")
+	}
+
+	return heightDB
+}
+
+func HeightKey(height uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, height)
+
+	return key
+}
+
+func (c *Client) GetHeightHash(height uint64) (SHA256Sum, error) {
+	hashBytes, err := c.dbm.heightDB.Get(HeightKey(height), nil)
+	if err != nil {
+		return SHA256Sum{}, err
+	}
+
+	if len(hashBytes) != SHA256_SUM_LENGTH {
+		return SHA256Sum{}, errors.New("Invalid hash length")
+	}
+
+	hash := SHA256Sum{}
+	copy(hash[:], hashBytes)
+
+	return hash, nil
+}
+
+func (c *Client) PutHeight(header BlockHeader) error {
+	hash := header.Hash()
+	return c.dbm.heightDB.Put(HeightKey(header.SeqNum), hash[:], nil)
+}
+
+/*
+ * Chain Work Database
+ *
+ * Maps the hash of every main, side, and orphan header to the cumulative work
+ * of the chain ending in that header.  An orphan only counts work back to its
+ * first missing ancestor, and is recomputed once the orphan is connected.
+ */
+
+func (c *Client) OpenWorkDB() *db.DB {
+	workDB, err := db.OpenFile(c.WorkDBPath, nil)
+	if err != nil {
+		log.Println(err)
+		panic("Unable to open chain work database")
+	}
+
+	return workDB
+}
+
+func (c *Client) GetChainWork(hash SHA256Sum) (*big.Int, error) {
+	workBytes, err := c.dbm.workDB.Get(hash[:], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	work := &big.Int{}
+	work.SetBytes(workBytes)
+
+	return work, nil
+}
+
+/*
+ * Records the work of a main or side chain header, which must extend a header
+ * whose work is already recorded.
+ */
+func (c *Client) PutChainWork(header BlockHeader) error {
+	work := header.Work()
+	if header.SeqNum != 0 {
+		prevWork, err := c.GetChainWork(header.PrevHash)
+		if err != nil {
+			log.Println(err)
+			return errors.New("Missing chain work for parent header")
+		}
+		work.Add(work, prevWork)
+	}
+
+	hash := header.Hash()
+	return c.dbm.workDB.Put(hash[:], work.Bytes(), nil)
+}
+
+/*
+ * Records the work of an orphan header, counting back only as far as its first
+ * missing ancestor.
+ */
+func (c *Client) PutOrphanChainWork(header BlockHeader) error {
+	work := header.Work()
+	prevWork, err := c.GetChainWork(header.PrevHash)
+	if err == nil {
+		work.Add(work, prevWork)
+	}
+
+	hash := header.Hash()
+	return c.dbm.workDB.Put(hash[:], work.Bytes(), nil)
+}
+
+/*
+ * Fee History Database
+ *
+ * Maps the hash of each block connected to the main chain to the fee data of
+ * its txns, so the fee estimator can be refilled on startup.
+ */
+
+func (c *Client) OpenFeeDB() *db.DB {
+	feeDB, err := db.OpenFile(c.FeeDBPath, nil)
+	if err != nil {
+		log.Println(err)
+		panic("Unable to open fee database")
+	}
+
+	return feeDB
+}
+
+func (c *Client) GetBlockFees(hash SHA256Sum) (*feeBlock, error) {
+	blockBytes, err := c.dbm.feeDB.Get(hash[:], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	block := &feeBlock{}
+	err = block.UnmarshalBinary(blockBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+func (c *Client) PutBlockFees(block feeBlock) error {
+	return c.dbm.feeDB.Put(block.hash[:], block.Bytes(), nil)
 }
 
 /*
@@ -114,8 +346,8 @@ func (c *Client) GetSideHeader(hash SHA256Sum) (*BlockHeader, error) {
 		return nil, nil
 	}
 
-	var header *BlockHeader
-	err = json.Unmarshal(headerBytes, header)
+	header := &BlockHeader{}
+	err = header.UnmarshalBinary(headerBytes)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -125,8 +357,13 @@ func (c *Client) GetSideHeader(hash SHA256Sum) (*BlockHeader, error) {
 }
 
 func (c *Client) PutSideHeader(header BlockHeader) error {
+	err := c.PutChainWork(header)
+	if err != nil {
+		return err
+	}
+
 	hash := header.Hash()
-	return c.dbm.sideHeaderDB.Put(hash[:], header.Json(), nil)
+	return c.dbm.sideHeaderDB.Put(hash[:], header.Bytes(), nil)
 }
 
 /*
@@ -153,8 +390,8 @@ func (c *Client) GetOrphanHeader(hash SHA256Sum) (*BlockHeader, error) {
 		return nil, nil
 	}
 
-	var header *BlockHeader
-	err = json.Unmarshal(headerBytes, header)
+	header := &BlockHeader{}
+	err = header.UnmarshalBinary(headerBytes)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -164,8 +401,13 @@ func (c *Client) GetOrphanHeader(hash SHA256Sum) (*BlockHeader, error) {
 }
 
 func (c *Client) PutOrphanHeader(header BlockHeader) error {
+	err := c.PutOrphanChainWork(header)
+	if err != nil {
+		return err
+	}
+
 	hash := header.Hash()
-	return c.dbm.orphanHeaderDB.Put(hash[:], header.Json(), nil)
+	return c.dbm.orphanHeaderDB.Put(hash[:], header.Bytes(), nil)
 }
 
 /*
@@ -189,7 +431,7 @@ func (c *Client) GetBlock(hash SHA256Sum) (*Block, error) {
 	}
 
 	block := &Block{}
-	err = json.Unmarshal(blockBytes, block)
+	err = block.UnmarshalBinary(blockBytes)
 	if err != nil {
 		return block, err
 	}
@@ -199,7 +441,7 @@ func (c *Client) GetBlock(hash SHA256Sum) (*Block, error) {
 
 func (c *Client) PutBlock(block Block) error {
 	hash := block.Header.Hash()
-	return c.dbm.blockDB.Put(hash[:], block.Json(), nil)
+	return c.dbm.blockDB.Put(hash[:], block.Bytes(), nil)
 }
 
 /*
@@ -222,8 +464,8 @@ func (c *Client) GetSideBlock(hash SHA256Sum) (*Block, error) {
 		return &Block{}, err
 	}
 
-	var sblock *Block
-	err = json.Unmarshal(sblockBytes, sblock)
+	sblock := &Block{}
+	err = sblock.UnmarshalBinary(sblockBytes)
 	if err != nil {
 		return &Block{}, err
 	}
@@ -233,7 +475,7 @@ func (c *Client) GetSideBlock(hash SHA256Sum) (*Block, error) {
 
 func (c *Client) PutSideBlock(block Block) error {
 	hash := block.Header.Hash()
-	return c.dbm.sideBlockDB.Put(hash[:], block.Json(), nil)
+	return c.dbm.sideBlockDB.Put(hash[:], block.Bytes(), nil)
 }
 
 /*
@@ -256,8 +498,8 @@ func (c *Client) GetOrphanBlock(hash SHA256Sum) (*Block, error) {
 		return &Block{}, err
 	}
 
-	var oblock *Block
-	err = json.Unmarshal(oblockBytes, oblock)
+	oblock := &Block{}
+	err = oblock.UnmarshalBinary(oblockBytes)
 	if err != nil {
 		return &Block{}, err
 	}
@@ -267,7 +509,7 @@ func (c *Client) GetOrphanBlock(hash SHA256Sum) (*Block, error) {
 
 func (c *Client) PutOrphanBlock(block Block) error {
 	hash := block.Header.Hash()
-	return c.dbm.orphanBlockDB.Put(hash[:], block.Json(), nil)
+	return c.dbm.orphanBlockDB.Put(hash[:], block.Bytes(), nil)
 }
 
 /*
@@ -344,12 +586,9 @@ func (c *Client) PutMapToBlock(block Block) error {
 
 	log.Println("Making map batch")
 	batch := &db.Batch{}
-	for i, txn := range block.Txns {
-		if i != 0 {
-			log.Println("Adding preimage")
-			pimgHash := Hash(txn.Sig.Preimage.Bytes())
-			batch.Put(pimgHash.Bytes(), blockHash.Bytes())
-		}
+	for _, txn := range block.Txns {
+		log.Println("Adding txn")
+		batch.Put(txn.Hash().Bytes(), blockHash.Bytes())
 		for _, output := range txn.Body.Outputs {
 			log.Println("Adding output")
 			batch.Put(output.Hash().Bytes(), blockHash.Bytes())
@@ -363,11 +602,8 @@ func (c *Client) PutMapToBlock(block Block) error {
 
 func (c *Client) DeleteMapToBlock(block Block) error {
 	batch := &db.Batch{}
-	for i, txn := range block.Txns {
-		if i != 0 {
-			pimgHash := Hash(txn.Sig.Preimage.Bytes())
-			batch.Delete(pimgHash.Bytes())
-		}
+	for _, txn := range block.Txns {
+		batch.Delete(txn.Hash().Bytes())
 		for _, output := range txn.Body.Outputs {
 			batch.Delete(output.Hash().Bytes())
 		}
@@ -408,57 +644,216 @@ func (c *Client) OpenTxnPoolDB() *db.DB {
 	return txnPoolDB
 }
 
+/*
+ * Rebuilds the pool index from the txn pool database, once on startup.
+ */
+func loadPoolIndex(txnPoolDB *db.DB) *poolIndex {
+	index := newPoolIndex()
+	iter := txnPoolDB.NewIterator(nil, nil)
+	for iter.Next() {
+		entry := PoolEntry{}
+		err := entry.UnmarshalBinary(iter.Value())
+		if err != nil {
+			log.Println("Could not unmarshal pool entry:", err)
+			continue
+		}
+
+		index.put(entry)
+	}
+	iter.Release()
+
+	return index
+}
+
 func (c *Client) GetTxnPool(hash SHA256Sum) (*Txn, error) {
-	txnBytes, err := c.dbm.txnPoolDB.Get(hash[:], nil)
+	entry, err := c.GetPoolEntry(hash)
 	if err != nil {
 		return nil, err
 	}
 
-	txn := &Txn{}
-	err = json.Unmarshal(txnBytes, txn)
+	return &entry.Txn, nil
+}
+
+func (c *Client) GetPoolEntry(hash SHA256Sum) (*PoolEntry, error) {
+	entryBytes, err := c.dbm.txnPoolDB.Get(hash[:], nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return txn, nil
+	entry := &PoolEntry{}
+	err = entry.UnmarshalBinary(entryBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
 }
 
+/*
+ * Adds a txn to the pool unless its preimage is already spent.  A pool txn with
+ * the same preimage is replaced only if the new txn pays a higher fee rate.  If
+ * the pool overflows, the lowest fee rate txns are evicted, which may include
+ * the new txn.
+ */
 func (c *Client) PutTxnPool(txn Txn) error {
 	hash := txn.Hash()
-	return c.dbm.txnPoolDB.Put(hash[:], txn.Json(), nil)
+	pimgHash := Hash(txn.Sig.Preimage.Bytes())
+
+	if c.GetPreimage(pimgHash) {
+		return errors.New("Txn preimage already spent")
+	}
+
+	existingHash, err := c.GetPoolPreimage(pimgHash)
+	if err == nil {
+		if existingHash == hash {
+			return nil
+		}
+
+		existing, err := c.GetTxnPool(existingHash)
+		if err == nil {
+			if compareFeeRate(txn, *existing) <= 0 {
+				return errors.New("Txn conflicts with pool txn")
+			}
+
+			log.Println("Replacing pool txn with higher fee rate txn")
+			err = c.dbm.txnPoolDB.Delete(existingHash[:], nil)
+			if err != nil {
+				return err
+			}
+			c.dbm.poolIndex.remove(existingHash)
+		}
+	}
+
+	entry := NewPoolEntry(txn, c.LastHeader.SeqNum)
+	err = c.dbm.txnPoolDB.Put(hash[:], entry.Bytes(), nil)
+	if err != nil {
+		return err
+	}
+	c.dbm.poolIndex.put(entry)
+
+	err = c.dbm.poolPimgDB.Put(pimgHash[:], hash[:], nil)
+	if err != nil {
+		return err
+	}
+
+	evicted, err := c.TrimTxnPool()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := evicted[hash]; ok {
+		return errors.New("Txn fee rate too low for full txn pool")
+	}
+
+	return nil
 }
 
 func (c *Client) DeleteTxnPool(txn Txn) error {
-	hash := txn.Hash()
-	return c.dbm.txnPoolDB.Delete(hash[:], nil)
+	return c.deletePoolEntry(txn.Hash(), Hash(txn.Sig.Preimage.Bytes()))
 }
 
+func (c *Client) deletePoolEntry(hash, pimgHash SHA256Sum) error {
+	err := c.dbm.txnPoolDB.Delete(hash[:], nil)
+	if err != nil {
+		return err
+	}
+	c.dbm.poolIndex.remove(hash)
+
+	// Only drop the preimage index if it still points at this txn
+	indexed, err := c.GetPoolPreimage(pimgHash)
+	if err != nil || indexed != hash {
+		return nil
+	}
+
+	return c.dbm.poolPimgDB.Delete(pimgHash[:], nil)
+}
+
+/*
+ * Loads every valid, unexpired pool txn, never yielding two txns with the same
+ * preimage or one whose preimage was already spent.
+ */
 func (c *Client) TxnsFromPool() []Txn {
 	txns := []Txn{}
-	iter := c.dbm.txnPoolDB.NewIterator(nil, nil)
-	for iter.Next() {
-		txnBytes := iter.Value()
+	preimages := make(map[SHA256Sum]struct{})
+	for _, entry := range c.PoolEntries() {
+		txn := entry.Txn
+		if c.PoolEntryExpired(entry) {
+			continue
+		}
 
-		txn := Txn{}
-		err := json.Unmarshal(txnBytes, &txn)
-		if err != nil {
-			log.Println("Could not marshal txn:", err)
+		pimgHash := Hash(txn.Sig.Preimage.Bytes())
+		if _, ok := preimages[pimgHash]; ok || c.GetPreimage(pimgHash) {
+			log.Println("Skipping conflicting pool txn")
 			continue
 		}
 
-		if !ValidTxn(txn) {
+		if !c.ValidTxn(txn) {
 			log.Println("INVALID TXN")
 			continue
 		}
 
-		if !c.VerifyTxn(txn, nil, nil, nil, nil) {
+		if !c.VerifyTxn(txn, c.LastHeader.SeqNum+1, nil, nil, nil, nil, nil) {
 			log.Println("TXN FAILED TO VERIFY")
 			continue
 		}
 
+		preimages[pimgHash] = SIGNAL
 		txns = append(txns, txn)
 	}
-	iter.Release()
 
 	return txns
 }
+
+/*
+ * Loads every entry in the txn pool.
+ */
+func (c *Client) PoolEntries() []PoolEntry {
+	entries := []PoolEntry{}
+	iter := c.dbm.txnPoolDB.NewIterator(nil, nil)
+	for iter.Next() {
+		entry := PoolEntry{}
+		err := entry.UnmarshalBinary(iter.Value())
+		if err != nil {
+			log.Println("Could not unmarshal pool entry:", err)
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+	iter.Release()
+
+	return entries
+}
+
+/*
+ * Txn Pool Preimage Index
+ *
+ * Maps the hash of each pool txn's preimage to the txn's hash, so conflicting
+ * spends are caught on admission.
+ */
+
+func (c *Client) OpenPoolPreimageDB() *db.DB {
+	poolPimgDB, err := db.OpenFile(c.PoolPImgDBPath, nil)
+	if err != nil {
+		log.Println(err)
+		panic("Unable to open txn pool preimage database")
+	}
+
+	return poolPimgDB
+}
+
+func (c *Client) GetPoolPreimage(pimgHash SHA256Sum) (SHA256Sum, error) {
+	hashBytes, err := c.dbm.poolPimgDB.Get(pimgHash[:], nil)
+	if err != nil {
+		return SHA256Sum{}, err
+	}
+
+	if len(hashBytes) != SHA256_SUM_LENGTH {
+		return SHA256Sum{}, errors.New("Invalid hash length")
+	}
+
+	hash := SHA256Sum{}
+	copy(hash[:], hashBytes)
+
+	return hash, nil
+}
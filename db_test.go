@@ -0,0 +1,55 @@
+package ozcoin
+
+import (
+	"testing"
+)
+
+func TestTxnPoolPreimageConflicts(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	original := selectionTxn(100, 1, 1)
+	cheaper := selectionTxn(50, 1, 1)
+	dearer := selectionTxn(500, 1, 1)
+
+	err := c.PutTxnPool(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.PutTxnPool(cheaper)
+	if err == nil {
+		t.Error("Lower fee rate conflict accepted")
+	}
+
+	err = c.PutTxnPool(dearer)
+	if err != nil {
+		t.Fatal("Higher fee rate replacement rejected:", err)
+	}
+
+	_, err = c.GetTxnPool(original.Hash())
+	if err == nil {
+		t.Error("Replaced txn still in pool")
+	}
+
+	indexed, err := c.GetPoolPreimage(Hash(dearer.Sig.Preimage.Bytes()))
+	if err != nil || indexed != dearer.Hash() {
+		t.Error("Preimage index does not point at replacement")
+	}
+}
+
+func TestTxnPoolRejectsSpentPreimage(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	txn := selectionTxn(100, 2, 1)
+	err := c.PutPreimage(Hash(txn.Sig.Preimage.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.PutTxnPool(txn)
+	if err == nil {
+		t.Error("Txn with spent preimage accepted")
+	}
+}
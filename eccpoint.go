@@ -1,23 +1,92 @@
 package ozcoin
 
 import (
+	"errors"
 	"math/big"
 )
 
+/*
+ * Points are encoded as 33 byte compressed SEC1, a prefix byte giving the
+ * parity of Y followed by the 32 byte X coordinate.  The point at infinity,
+ * represented as (0, 0), is encoded as 33 zero bytes.
+ */
+
+const (
+	POINT_LENGTH            = 33
+	POINT_EVEN_PREFIX uint8 = 0x02
+	POINT_ODD_PREFIX  uint8 = 0x03
+)
+
 type ECCPoint struct {
 	X *big.Int `json:"x"`
 	Y *big.Int `json:"y"`
 }
 
+/*
+ * Returns the compressed encoding, an empty point is encoded as the point at
+ * infinity.
+ */
 func (p ECCPoint) Bytes() []byte {
-	data := []byte{}
-	data = append(data, p.X.Bytes()...)
-	data = append(data, p.Y.Bytes()...)
-
-	return data
+	return CURVE.Encode(p)
 }
 
 func (p ECCPoint) Empty() bool {
 	return p.X == nil ||
 		p.Y == nil
 }
+
+/*
+ * The point at infinity, represented as (0, 0) by crypto/elliptic.
+ */
+func (p ECCPoint) IsIdentity() bool {
+	return !p.Empty() &&
+		p.X.Sign() == 0 &&
+		p.Y.Sign() == 0
+}
+
+/*
+ * True if the point is on CURVE and is not the point at infinity.
+ */
+func (p ECCPoint) Valid() bool {
+	return !p.Empty() &&
+		!p.IsIdentity() &&
+		CURVE.IsOnCurve(p)
+}
+
+func (p ECCPoint) Equal(q ECCPoint) bool {
+	return !p.Empty() &&
+		!q.Empty() &&
+		p.X.Cmp(q.X) == 0 &&
+		p.Y.Cmp(q.Y) == 0
+}
+
+/*
+ * Returns -p, the point at infinity is its own negation.
+ */
+func (p ECCPoint) Neg() ECCPoint {
+	return CURVE.Neg(p)
+}
+
+/*
+ * Decodes a compressed point, rejecting the point at infinity.
+ */
+func DecodePoint(b []byte) (ECCPoint, error) {
+	p, err := CURVE.Decode(b)
+	if err != nil {
+		return ECCPoint{}, err
+	}
+
+	if p.IsIdentity() {
+		return ECCPoint{}, errors.New("Point at infinity")
+	}
+
+	return p, nil
+}
+
+/*
+ * Decodes a compressed point, allowing the point at infinity for the few
+ * fields that may legitimately hold it.
+ */
+func decodePointOrIdentity(b []byte) (ECCPoint, error) {
+	return CURVE.Decode(b)
+}
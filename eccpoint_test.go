@@ -0,0 +1,94 @@
+package ozcoin
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"math/big"
+	"testing"
+)
+
+func TestPointBytesFixedLength(t *testing.T) {
+	// Small coordinates used to encode to fewer bytes and collide
+	a := ECCPoint{big.NewInt(1), big.NewInt(0x0203)}
+	b := ECCPoint{big.NewInt(0x0102), big.NewInt(0x03)}
+
+	if len(a.Bytes()) != POINT_LENGTH || len(b.Bytes()) != POINT_LENGTH {
+		t.Fatal("Point encoding is not fixed length")
+	}
+
+	if bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Error("Distinct points share an encoding")
+	}
+
+	if HashPt(nil, a) == HashPt(nil, b) {
+		t.Error("Distinct points share a hash")
+	}
+}
+
+func TestDecodePoint(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		p := CURVE.BaseMult(RandomInt().Bytes())
+
+		decoded, err := DecodePoint(p.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.X.Cmp(p.X) != 0 || decoded.Y.Cmp(p.Y) != 0 {
+			t.Error("Point changed in round trip")
+		}
+	}
+}
+
+func TestDecodePointRejectsInvalid(t *testing.T) {
+	g := CURVE.BaseMult([]byte{1})
+
+	// Compressed x = 1 has no matching y on P-256
+	offCurve := make([]byte, POINT_LENGTH)
+	offCurve[0] = POINT_EVEN_PREFIX
+	offCurve[POINT_LENGTH-1] = 1
+
+	outOfRange := make([]byte, POINT_LENGTH)
+	outOfRange[0] = POINT_EVEN_PREFIX
+	elliptic.P256().Params().P.FillBytes(outOfRange[1:])
+
+	badPrefix := g.Bytes()
+	badPrefix[0] = 0x04
+
+	encodings := map[string][]byte{
+		"identity":     make([]byte, POINT_LENGTH),
+		"off curve":    offCurve,
+		"out of range": outOfRange,
+		"prefix":       badPrefix,
+		"truncated":    g.Bytes()[:POINT_LENGTH-1],
+	}
+
+	for name, encoding := range encodings {
+		_, err := DecodePoint(encoding)
+		if err == nil {
+			t.Error("Decoded invalid point:", name)
+		}
+	}
+
+	_, err := decodePointOrIdentity(make([]byte, POINT_LENGTH))
+	if err != nil {
+		t.Error("Rejected point at infinity where allowed:", err)
+	}
+}
+
+func TestPointNeg(t *testing.T) {
+	p := CURVE.BaseMult(RandomInt().Bytes())
+	neg := p.Neg()
+
+	if !neg.Valid() {
+		t.Fatal("Negated point not on curve")
+	}
+
+	if !CURVE.Add(p, neg).IsIdentity() {
+		t.Error("Point plus its negation is not the identity")
+	}
+
+	if !(ECCPoint{&big.Int{}, &big.Int{}}).Neg().IsIdentity() {
+		t.Error("Negated identity is not the identity")
+	}
+}
@@ -0,0 +1,497 @@
+package ozcoin
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"math/big"
+	"time"
+)
+
+/*
+ * Canonical binary encoding
+ *
+ * Blocks, headers, txns and outputs are hashed, stored and sent to peers in a
+ * single binary encoding.  Every top level encoding starts with the
+ * `ENCODING_VERSION` byte.  Integers are fixed width and big-endian, times are
+ * unix seconds, scalars are 32 bytes and below the curve order, points are 33
+ * byte compressed SEC1 with the point at infinity as 33 zero bytes, and lists
+ * are prefixed by a uint32 count.
+ */
+
+const (
+	ENCODING_VERSION uint8 = 1
+)
+
+type encoder struct {
+	buf []byte
+	err error
+}
+
+func newEncoder() *encoder {
+	e := &encoder{}
+	e.putUint8(ENCODING_VERSION)
+
+	return e
+}
+
+func (e *encoder) putUint8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) putUint32(v uint32) {
+	b := [4]byte{}
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) putUint64(v uint64) {
+	b := [8]byte{}
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) putCount(n int) {
+	e.putUint32(uint32(n))
+}
+
+func (e *encoder) putHash(h SHA256Sum) {
+	e.buf = append(e.buf, h[:]...)
+}
+
+func (e *encoder) putTime(t time.Time) {
+	e.putUint64(uint64(t.Unix()))
+}
+
+func (e *encoder) putScalar(s Scalar) {
+	e.buf = append(e.buf, s[:]...)
+}
+
+/*
+ * Writes the compressed point, an empty point is written as the point at
+ * infinity.
+ */
+func (e *encoder) putPoint(p ECCPoint) {
+	if !p.Empty() && !p.IsIdentity() && !CURVE.IsOnCurve(p) {
+		e.fail(errors.New("Point not on curve"))
+		return
+	}
+
+	e.buf = append(e.buf, p.Bytes()...)
+}
+
+func (e *encoder) fail(err error) {
+	if e.err == nil {
+		e.err = err
+	}
+}
+
+func (e *encoder) bytes() ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	return e.buf, nil
+}
+
+type decoder struct {
+	data []byte
+	err  error
+}
+
+/*
+ * Starts decoding `data`, which must begin with the current version byte.
+ */
+func newDecoder(data []byte) *decoder {
+	d := &decoder{data: data}
+	if d.getUint8() != ENCODING_VERSION && d.err == nil {
+		d.fail(errors.New("Unsupported encoding version"))
+	}
+
+	return d
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if len(d.data) < n {
+		d.fail(errors.New("Unexpected end of encoding"))
+		return nil
+	}
+
+	b := d.data[:n]
+	d.data = d.data[n:]
+
+	return b
+}
+
+func (d *decoder) getUint8() uint8 {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+
+	return b[0]
+}
+
+func (d *decoder) getUint32() uint32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint32(b)
+}
+
+func (d *decoder) getUint64() uint64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(b)
+}
+
+/*
+ * Reads a list count, refusing counts that could not fit in the remaining
+ * bytes given each item's minimum size.
+ */
+func (d *decoder) getCount(itemSize int) int {
+	n := d.getUint32()
+	if uint64(n)*uint64(itemSize) > uint64(len(d.data)) {
+		d.fail(errors.New("List count exceeds encoding"))
+		return 0
+	}
+
+	return int(n)
+}
+
+func (d *decoder) getHash() SHA256Sum {
+	h := SHA256Sum{}
+	copy(h[:], d.next(SHA256_SUM_LENGTH))
+
+	return h
+}
+
+func (d *decoder) getTime() time.Time {
+	return time.Unix(int64(d.getUint64()), 0).UTC()
+}
+
+/*
+ * Reads a scalar, rejecting any at or above the curve order.
+ */
+func (d *decoder) getScalar() Scalar {
+	s := Scalar{}
+	copy(s[:], d.next(SCALAR_LENGTH))
+	if !s.Canonical() {
+		d.fail(errors.New("Non-canonical scalar"))
+	}
+
+	return s
+}
+
+func (d *decoder) getPoint() ECCPoint {
+	return d.decodePoint(DecodePoint)
+}
+
+/*
+ * Reads a point that may be the point at infinity.
+ */
+func (d *decoder) getPointOrIdentity() ECCPoint {
+	return d.decodePoint(decodePointOrIdentity)
+}
+
+func (d *decoder) decodePoint(decode func([]byte) (ECCPoint, error)) ECCPoint {
+	b := d.next(POINT_LENGTH)
+	if b == nil {
+		return ECCPoint{&big.Int{}, &big.Int{}}
+	}
+
+	p, err := decode(b)
+	if err != nil {
+		d.fail(err)
+		return ECCPoint{&big.Int{}, &big.Int{}}
+	}
+
+	return p
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+/*
+ * Finishes decoding, every byte must have been consumed.
+ */
+func (d *decoder) finish() error {
+	if d.err != nil {
+		return d.err
+	}
+
+	if len(d.data) != 0 {
+		return errors.New("Trailing bytes after encoding")
+	}
+
+	return nil
+}
+
+/*
+ * Panics if a value built by this client can't be encoded, since its hash
+ * would be meaningless.
+ */
+func mustEncode(b []byte, err error) []byte {
+	if err != nil {
+		log.Println(err)
+		panic("Unable to encode")
+	}
+
+	return b
+}
+
+/*
+ * Block headers
+ */
+
+func (bh BlockHeader) encode(e *encoder) {
+	e.putUint64(bh.SeqNum)
+	e.putHash(bh.PrevHash)
+	e.putHash(bh.MerkleRoot)
+	e.putTime(bh.Time)
+	e.putUint32(bh.Bits)
+	e.putUint64(bh.Nonce)
+}
+
+func (bh *BlockHeader) decode(d *decoder) {
+	bh.SeqNum = d.getUint64()
+	bh.PrevHash = d.getHash()
+	bh.MerkleRoot = d.getHash()
+	bh.Time = d.getTime()
+	bh.Bits = d.getUint32()
+	bh.Nonce = d.getUint64()
+}
+
+func (bh BlockHeader) MarshalBinary() ([]byte, error) {
+	e := newEncoder()
+	bh.encode(e)
+
+	return e.bytes()
+}
+
+func (bh *BlockHeader) UnmarshalBinary(data []byte) error {
+	d := newDecoder(data)
+	bh.decode(d)
+
+	return d.finish()
+}
+
+/*
+ * The canonical encoding of the header.
+ */
+func (bh BlockHeader) Bytes() []byte {
+	return mustEncode(bh.MarshalBinary())
+}
+
+/*
+ * Blocks
+ */
+
+func (b Block) MarshalBinary() ([]byte, error) {
+	e := newEncoder()
+	b.Header.encode(e)
+	e.putCount(len(b.Txns))
+	for _, txn := range b.Txns {
+		txn.encode(e)
+	}
+
+	return e.bytes()
+}
+
+func (b *Block) UnmarshalBinary(data []byte) error {
+	d := newDecoder(data)
+	b.Header.decode(d)
+
+	n := d.getCount(1)
+	b.Txns = make([]Txn, n)
+	for i := range b.Txns {
+		b.Txns[i].decode(d)
+	}
+
+	return d.finish()
+}
+
+/*
+ * The canonical encoding of the block.
+ */
+func (b Block) Bytes() []byte {
+	return mustEncode(b.MarshalBinary())
+}
+
+/*
+ * Txns
+ */
+
+func (body TxnBody) encode(e *encoder) {
+	e.putCount(len(body.Inputs))
+	for _, inp := range body.Inputs {
+		e.putHash(inp)
+	}
+
+	e.putCount(len(body.Outputs))
+	for _, output := range body.Outputs {
+		output.encode(e)
+	}
+
+	e.putUint64(body.Fee)
+	e.putUint64(body.ExtraNonce)
+}
+
+func (body *TxnBody) decode(d *decoder) {
+	body.Inputs = make([]SHA256Sum, d.getCount(SHA256_SUM_LENGTH))
+	for i := range body.Inputs {
+		body.Inputs[i] = d.getHash()
+	}
+
+	body.Outputs = make([]Output, d.getCount(1))
+	for i := range body.Outputs {
+		body.Outputs[i].decode(d)
+	}
+
+	body.Fee = d.getUint64()
+	body.ExtraNonce = d.getUint64()
+}
+
+func (sig OZRS) encode(e *encoder) {
+	e.putPoint(sig.Preimage)
+	e.putHash(sig.E)
+
+	e.putCount(len(sig.Rs))
+	for _, r := range sig.Rs {
+		e.putScalar(r)
+	}
+
+	e.putCount(len(sig.Ss))
+	for _, s := range sig.Ss {
+		e.putScalar(s)
+	}
+}
+
+func (sig *OZRS) decode(d *decoder) {
+	sig.Preimage = d.getPointOrIdentity()
+	sig.E = d.getHash()
+
+	sig.Rs = make([]Scalar, d.getCount(SCALAR_LENGTH))
+	for i := range sig.Rs {
+		sig.Rs[i] = d.getScalar()
+	}
+
+	sig.Ss = make([]Scalar, d.getCount(SCALAR_LENGTH))
+	for i := range sig.Ss {
+		sig.Ss[i] = d.getScalar()
+	}
+}
+
+func (txn Txn) encode(e *encoder) {
+	txn.Body.encode(e)
+	txn.Sig.encode(e)
+}
+
+func (txn *Txn) decode(d *decoder) {
+	txn.Body.decode(d)
+	txn.Sig.decode(d)
+}
+
+func (txn Txn) MarshalBinary() ([]byte, error) {
+	e := newEncoder()
+	txn.encode(e)
+
+	return e.bytes()
+}
+
+func (txn *Txn) UnmarshalBinary(data []byte) error {
+	d := newDecoder(data)
+	txn.decode(d)
+
+	return d.finish()
+}
+
+/*
+ * The canonical encoding of the txn.
+ */
+func (txn Txn) Bytes() []byte {
+	return mustEncode(txn.MarshalBinary())
+}
+
+/*
+ * The canonical encoding of the txn body, the message signed by the OZRS.
+ */
+func (txn Txn) BodyBytes() []byte {
+	e := newEncoder()
+	txn.Body.encode(e)
+
+	return mustEncode(e.bytes())
+}
+
+/*
+ * Outputs
+ */
+
+func (rp RangeProof) encode(e *encoder) {
+	e.putHash(rp.E)
+	for i := 0; i < RANGE_PROOF_LENGTH; i++ {
+		e.putScalar(rp.Ss[i][0])
+		e.putScalar(rp.Ss[i][1])
+		e.putPoint(rp.PKs[i][0])
+		e.putPoint(rp.PKs[i][1])
+	}
+}
+
+func (rp *RangeProof) decode(d *decoder) {
+	rp.E = d.getHash()
+	for i := 0; i < RANGE_PROOF_LENGTH; i++ {
+		rp.Ss[i][0] = d.getScalar()
+		rp.Ss[i][1] = d.getScalar()
+		rp.PKs[i][0] = d.getPointOrIdentity()
+		rp.PKs[i][1] = d.getPointOrIdentity()
+	}
+}
+
+func (o Output) encode(e *encoder) {
+	e.putPoint(o.PublicKey)
+	e.putPoint(o.DestKey)
+	e.putPoint(o.BlindSeed)
+	e.putPoint(o.Commit.ECCPoint)
+	o.Commit.RangeProof.encode(e)
+}
+
+func (o *Output) decode(d *decoder) {
+	o.PublicKey = d.getPoint()
+	o.DestKey = d.getPoint()
+	o.BlindSeed = d.getPointOrIdentity()
+	o.Commit.ECCPoint = d.getPointOrIdentity()
+	o.Commit.RangeProof.decode(d)
+}
+
+func (o Output) MarshalBinary() ([]byte, error) {
+	e := newEncoder()
+	o.encode(e)
+
+	return e.bytes()
+}
+
+func (o *Output) UnmarshalBinary(data []byte) error {
+	d := newDecoder(data)
+	o.decode(d)
+
+	return d.finish()
+}
+
+/*
+ * The canonical encoding of the output.
+ */
+func (o Output) Bytes() []byte {
+	return mustEncode(o.MarshalBinary())
+}
@@ -0,0 +1,202 @@
+package ozcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testHeader() BlockHeader {
+	header := BlockHeader{
+		SeqNum: 7,
+		Time:   time.Unix(1454198400, 0).UTC(),
+		Bits:   0x207fffff,
+		Nonce:  42,
+	}
+	for i := range header.PrevHash {
+		header.PrevHash[i] = 0x11
+		header.MerkleRoot[i] = 0x22
+	}
+
+	return header
+}
+
+/*
+ * Builds a signed txn spending the first of a ring of fresh inputs.
+ */
+func testSignedTxn() Txn {
+	amts := []uint64{1, 4999999998}
+	rcpts := []WalletPublicKey{
+		NewPrivateKey().PublicKey(),
+		NewPrivateKey().PublicKey(),
+	}
+
+	pks, sec := pksAndSecret()
+	ics, yi := commitmentsAndBF(5000000000)
+	outputs, bf := BuildOutputs(amts, rcpts)
+
+	txn := Txn{
+		Body: TxnBody{
+			Inputs:  make([]SHA256Sum, len(pks)),
+			Outputs: outputs,
+			Fee:     1,
+		},
+	}
+	txn.OZRSSign(pks, ics, sec, yi, 0, bf, HASH_TO_PT_TRY_INCREMENT)
+
+	return txn
+}
+
+func TestHeaderEncodingVector(t *testing.T) {
+	expected := "01" + // version
+		"0000000000000007" + // seq num
+		"1111111111111111111111111111111111111111111111111111111111111111" +
+		"2222222222222222222222222222222222222222222222222222222222222222" +
+		"0000000056ad4e80" + // time
+		"207fffff" + // bits
+		"000000000000002a" // nonce
+
+	header := testHeader()
+	encoded := hex.EncodeToString(header.Bytes())
+	if encoded != expected {
+		t.Fatalf("Expected %s, got %s", expected, encoded)
+	}
+
+	hash := header.Hash()
+	if hex.EncodeToString(hash[:]) != "b07230c05943d284da637861c148d39b85c951e497d5c127e3d58686f0cdd995" {
+		t.Error("Unexpected header hash", hex.EncodeToString(hash[:]))
+	}
+
+	decoded := BlockHeader{}
+	err := decoded.UnmarshalBinary(header.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded != header {
+		t.Error("Header changed in round trip")
+	}
+}
+
+func TestPointEncodingVector(t *testing.T) {
+	points := []struct {
+		point    ECCPoint
+		expected string
+	}{
+		{
+			CURVE.BaseMult([]byte{1}),
+			"036b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c296",
+		},
+		{
+			ECCPoint{&big.Int{}, &big.Int{}},
+			"000000000000000000000000000000000000000000000000000000000000000000",
+		},
+	}
+
+	for _, test := range points {
+		e := &encoder{}
+		e.putPoint(test.point)
+		encoded, err := e.bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hex.EncodeToString(encoded) != test.expected {
+			t.Errorf("Expected %s, got %x", test.expected, encoded)
+		}
+
+		decoded, err := decodePointOrIdentity(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.X.Cmp(test.point.X) != 0 || decoded.Y.Cmp(test.point.Y) != 0 {
+			t.Error("Point changed in round trip")
+		}
+	}
+}
+
+func TestTxnRoundTrip(t *testing.T) {
+	coinbase := NewCoinbaseTxn(NewPrivateKey().PublicKey(), 5000000000)
+	for _, txn := range []Txn{testSignedTxn(), coinbase} {
+		encoded := txn.Bytes()
+
+		decoded := Txn{}
+		err := decoded.UnmarshalBinary(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(decoded.Bytes(), encoded) {
+			t.Error("Txn encoding changed in round trip")
+		}
+
+		if decoded.Hash() != txn.Hash() {
+			t.Error("Txn hash changed in round trip")
+		}
+	}
+
+	// The signature must still verify over the decoded body
+	txn := testSignedTxn()
+	decoded := Txn{}
+	err := decoded.UnmarshalBinary(txn.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, output := range decoded.Body.Outputs {
+		if !output.Commit.RangeProof.Verify() {
+			t.Error("Range proof failed to verify after round trip")
+		}
+	}
+}
+
+func TestBlockRoundTrip(t *testing.T) {
+	block := GenesisBlock(&RegTestParams)
+	block.Txns = append(block.Txns, testSignedTxn())
+
+	decoded := Block{}
+	err := decoded.UnmarshalBinary(block.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Header.Hash() != block.Header.Hash() || len(decoded.Txns) != 2 {
+		t.Fatal("Block changed in round trip")
+	}
+
+	for i := range block.Txns {
+		if decoded.Txns[i].Hash() != block.Txns[i].Hash() {
+			t.Error("Block txn changed in round trip")
+		}
+	}
+}
+
+func TestDecodeRejectsMalformed(t *testing.T) {
+	valid := testHeader().Bytes()
+
+	wrongVersion := append([]byte{}, valid...)
+	wrongVersion[0] = ENCODING_VERSION + 1
+
+	encodings := map[string][]byte{
+		"version":   wrongVersion,
+		"truncated": valid[:len(valid)-1],
+		"trailing":  append(append([]byte{}, valid...), 0),
+	}
+
+	for name, encoding := range encodings {
+		header := BlockHeader{}
+		if header.UnmarshalBinary(encoding) == nil {
+			t.Error("Accepted malformed header:", name)
+		}
+	}
+
+	// A txn claiming more inputs than it has bytes for
+	huge := []byte{ENCODING_VERSION, 0xff, 0xff, 0xff, 0xff}
+	txn := Txn{}
+	if txn.UnmarshalBinary(huge) == nil {
+		t.Error("Accepted oversized input count")
+	}
+}
@@ -0,0 +1,246 @@
+package ozcoin
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+)
+
+const (
+	FEE_HISTORY_BLOCKS = 100 // recent main chain blocks tracked
+	FEE_SUCCESS_PCT    = 85  // pct of txns at a rate that must meet the target
+	FEE_DEFAULT_TARGET = 6   // blocks, used by the wallet when no fee is given
+)
+
+/*
+ * confirmedTxn
+ *
+ * Fee data for a txn confirmed on the main chain after waiting `waited` blocks
+ * in the txn pool.
+ */
+type confirmedTxn struct {
+	fee    uint64
+	size   uint64
+	waited uint64
+}
+
+type byConfirmedFeeRate []confirmedTxn
+
+func (b byConfirmedFeeRate) Len() int      { return len(b) }
+func (b byConfirmedFeeRate) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byConfirmedFeeRate) Less(i, j int) bool {
+	return compareRates(b[i].fee, b[i].size, b[j].fee, b[j].size) > 0
+}
+
+type feeBlock struct {
+	hash SHA256Sum
+	txns []confirmedTxn
+}
+
+func (fb feeBlock) MarshalBinary() ([]byte, error) {
+	e := newEncoder()
+	e.putHash(fb.hash)
+	e.putCount(len(fb.txns))
+	for _, txn := range fb.txns {
+		e.putUint64(txn.fee)
+		e.putUint64(txn.size)
+		e.putUint64(txn.waited)
+	}
+
+	return e.bytes()
+}
+
+func (fb *feeBlock) UnmarshalBinary(data []byte) error {
+	d := newDecoder(data)
+	fb.hash = d.getHash()
+	fb.txns = make([]confirmedTxn, d.getCount(24))
+	for i := range fb.txns {
+		fb.txns[i].fee = d.getUint64()
+		fb.txns[i].size = d.getUint64()
+		fb.txns[i].waited = d.getUint64()
+	}
+
+	return d.finish()
+}
+
+func (fb feeBlock) Bytes() []byte {
+	return mustEncode(fb.MarshalBinary())
+}
+
+/*
+ * FeeEstimator
+ *
+ * Tracks the fee rates of txns confirmed in the most recent main chain blocks
+ * and how many blocks each spent in the txn pool.  Txns that were never seen in
+ * the pool are not tracked, since their wait is unknown.
+ */
+type FeeEstimator struct {
+	mtx    sync.Mutex
+	blocks []feeBlock
+}
+
+func NewFeeEstimator() *FeeEstimator {
+	return &FeeEstimator{
+		blocks: []feeBlock{},
+	}
+}
+
+/*
+ * Records the fee data for a newly connected main chain block, forgetting the
+ * oldest block once `FEE_HISTORY_BLOCKS` are tracked.
+ */
+func (fe *FeeEstimator) ConnectBlock(hash SHA256Sum, txns []confirmedTxn) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	fe.blocks = append(fe.blocks, feeBlock{hash, txns})
+	if len(fe.blocks) > FEE_HISTORY_BLOCKS {
+		fe.blocks = fe.blocks[len(fe.blocks)-FEE_HISTORY_BLOCKS:]
+	}
+}
+
+/*
+ * Forgets the fee data of a block removed from the main chain.
+ */
+func (fe *FeeEstimator) DisconnectBlock(hash SHA256Sum) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	for i, block := range fe.blocks {
+		if block.hash == hash {
+			fe.blocks = append(fe.blocks[:i], fe.blocks[i+1:]...)
+			return
+		}
+	}
+}
+
+/*
+ * Returns the lowest fee rate, in fees per serialized byte, at which at least
+ * `FEE_SUCCESS_PCT` percent of tracked txns paying that rate or more were
+ * confirmed within `targetBlocks` blocks.
+ */
+func (fe *FeeEstimator) EstimateFee(targetBlocks uint64) (uint64, error) {
+	if targetBlocks == 0 {
+		return 0, errors.New("Fee target must be at least one block")
+	}
+
+	fe.mtx.Lock()
+	txns := []confirmedTxn{}
+	for _, block := range fe.blocks {
+		txns = append(txns, block.txns...)
+	}
+	fe.mtx.Unlock()
+
+	if len(txns) == 0 {
+		return 0, errors.New("No confirmed txns to estimate fee from")
+	}
+
+	// Highest fee rate first
+	sort.Sort(byConfirmedFeeRate(txns))
+
+	best := -1
+	within := 0
+	for i, txn := range txns {
+		if txn.waited <= targetBlocks {
+			within++
+		}
+
+		if within*100 >= (i+1)*FEE_SUCCESS_PCT {
+			best = i
+		}
+	}
+
+	if best < 0 {
+		return 0, errors.New("No fee rate confirms within target")
+	}
+
+	// Round up so the estimate never falls below the observed rate
+	txn := txns[best]
+	return (txn.fee + txn.size - 1) / txn.size, nil
+}
+
+/*
+ * Records the fee rate and pool wait of every txn in a block connected to the
+ * main chain, persisting them for `LoadFeeHistory`.  Must be called before the
+ * block's txns leave the pool.
+ */
+func (c *Client) RecordConfirmedFees(block Block) {
+	txns := []confirmedTxn{}
+	for i, txn := range block.Txns {
+		// Skip the coinbase
+		if i == 0 {
+			continue
+		}
+
+		entry, err := c.GetPoolEntry(txn.Hash())
+		if err != nil {
+			continue
+		}
+
+		waited := uint64(1)
+		if block.Header.SeqNum > entry.Height {
+			waited = block.Header.SeqNum - entry.Height
+		}
+
+		txns = append(txns, confirmedTxn{
+			fee:    txn.Body.Fee,
+			size:   uint64(txn.Size()),
+			waited: waited,
+		})
+	}
+
+	hash := block.Header.Hash()
+	err := c.PutBlockFees(feeBlock{hash, txns})
+	if err != nil {
+		log.Println("Could not persist block fees:", err)
+	}
+
+	c.Fees.ConnectBlock(hash, txns)
+}
+
+/*
+ * Refills the fee estimator from the persisted fee data of the most recent
+ * `FEE_HISTORY_BLOCKS` main chain blocks, so estimates survive a restart.
+ */
+func (c *Client) LoadFeeHistory() {
+	c.Fees = NewFeeEstimator()
+
+	start := uint64(0)
+	if c.LastHeader.SeqNum >= FEE_HISTORY_BLOCKS {
+		start = c.LastHeader.SeqNum - FEE_HISTORY_BLOCKS + 1
+	}
+
+	for height := start; height <= c.LastHeader.SeqNum; height++ {
+		hash, err := c.GetHeightHash(height)
+		if err != nil {
+			continue
+		}
+
+		block, err := c.GetBlockFees(hash)
+		if err != nil {
+			continue
+		}
+
+		c.Fees.ConnectBlock(block.hash, block.txns)
+	}
+}
+
+/*
+ * Estimates the fee rate, in fees per serialized byte, needed for a txn to be
+ * confirmed within `targetBlocks` blocks.  The estimate is raised above the
+ * pool's minimum fee rate when the pool is full.
+ */
+func (c *Client) EstimateFee(targetBlocks uint64) (uint64, error) {
+	rate, err := c.Fees.EstimateFee(targetBlocks)
+	if err != nil {
+		return 0, err
+	}
+
+	minRate := c.PoolInfo().MinFeeRate
+	if minRate > 0 && float64(rate) <= minRate {
+		rate = uint64(minRate) + 1
+	}
+
+	return rate, nil
+}
@@ -0,0 +1,176 @@
+package ozcoin
+
+import (
+	"testing"
+)
+
+func TestEstimateFee(t *testing.T) {
+	fe := NewFeeEstimator()
+
+	_, err := fe.EstimateFee(1)
+	if err == nil {
+		t.Error("Estimated fee without data")
+	}
+
+	// High fee rates confirm in the next block, low ones wait
+	fe.ConnectBlock(SHA256Sum{1}, []confirmedTxn{
+		{fee: 1000, size: 100, waited: 1},
+		{fee: 900, size: 100, waited: 1},
+		{fee: 500, size: 100, waited: 3},
+		{fee: 100, size: 100, waited: 10},
+	})
+
+	tests := []struct {
+		target   uint64
+		expected uint64
+	}{
+		{1, 9},
+		{3, 5},
+		{10, 1},
+	}
+
+	for _, test := range tests {
+		rate, err := fe.EstimateFee(test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rate != test.expected {
+			t.Errorf("Target %d: expected rate %d, got %d", test.target,
+				test.expected, rate)
+		}
+	}
+
+	_, err = fe.EstimateFee(0)
+	if err == nil {
+		t.Error("Estimated fee for zero blocks")
+	}
+
+	fe.DisconnectBlock(SHA256Sum{1})
+	_, err = fe.EstimateFee(1)
+	if err == nil {
+		t.Error("Disconnected block still used for estimate")
+	}
+}
+
+func TestEstimateFeeHistoryLimit(t *testing.T) {
+	fe := NewFeeEstimator()
+
+	fe.ConnectBlock(SHA256Sum{}, []confirmedTxn{
+		{fee: 1, size: 1, waited: 1},
+	})
+	for i := 0; i < FEE_HISTORY_BLOCKS; i++ {
+		fe.ConnectBlock(SHA256Sum{byte(i + 1)}, []confirmedTxn{
+			{fee: 700, size: 100, waited: 1},
+		})
+	}
+
+	rate, err := fe.EstimateFee(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rate != 7 {
+		t.Error("Oldest block not forgotten, got rate", rate)
+	}
+}
+
+func TestFeeHistorySurvivesRestart(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	priv := NewPrivateKey()
+	addr := priv.PublicKey()
+	inputs := extendTestChain(t, c, addr, c.Params.TxnNumInputs)
+	extendTestChain(t, c, addr, int(c.Params.CoinbaseMaturity))
+
+	value := c.Params.CoinbaseValue(1)
+	txn := spendTestCoinbase(c, *priv, inputs, value, 1000)
+	err = c.AdmitTxn(txn)
+	if err != nil {
+		t.Fatal("Txn rejected:", err)
+	}
+
+	block := mineTestBlock(c, c.LastHeader, addr, []Txn{txn})
+	success, err := c.ExtendMainChain(block.Header, &block)
+	if !success || err != nil {
+		t.Fatal("Could not extend main chain:", err)
+	}
+
+	expected, err := c.EstimateFee(FEE_DEFAULT_TARGET)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted client starts with the same history
+	restarted := restartTestClient(t, c)
+	defer restarted.dbm.CloseConnections()
+
+	rate, err := restarted.EstimateFee(FEE_DEFAULT_TARGET)
+	if err != nil {
+		t.Fatal("No fee estimate after restart:", err)
+	}
+
+	if rate != expected {
+		t.Errorf("Expected rate %d after restart, got %d", expected, rate)
+	}
+}
+
+func TestFeeHistoryAfterReorg(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := NewPrivateKey().PublicKey()
+	extendTestChain(t, c, addr, 3)
+
+	// Fork off height 1, overtaking the main chain by one block
+	fork, err := c.HeaderAtHeight(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := *fork
+	for i := 0; i < 3; i++ {
+		block := mineTestBlock(c, prev, addr, nil)
+		success, err := c.ExtendSideChain(block.Header, &block)
+		if !success || err != nil {
+			t.Fatal("Could not extend side chain:", err)
+		}
+
+		prev = block.Header
+	}
+
+	if c.LastHeader.Hash() != prev.Hash() {
+		t.Fatal("Heavier fork not adopted")
+	}
+
+	// History follows the new main chain, oldest block first
+	c.Fees.mtx.Lock()
+	blocks := append([]feeBlock{}, c.Fees.blocks...)
+	c.Fees.mtx.Unlock()
+
+	if len(blocks) != int(c.LastHeader.SeqNum)+1 {
+		t.Fatal("Expected a fee block per main chain block, got", len(blocks))
+	}
+
+	for height, block := range blocks {
+		hash, err := c.GetHeightHash(uint64(height))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if block.hash != hash {
+			t.Error("Fee history out of order at height", height)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package ozcoin
 
 import (
-	"encoding/json"
 	"errors"
 	"log"
 	"net"
@@ -14,25 +13,57 @@ const (
 	BLOCK_RETRIEVAL_LIMIT = 25
 )
 
+/*
+ * GossipCore
+ *
+ * Serves one peer connection.  `remote` is the host the connection came from,
+ * which unlike the address in a request cannot be chosen by the peer.
+ */
 type GossipCore struct {
-	c *Client
+	c      *Client
+	remote string
 }
 
 func (s *Client) Serve() error {
-	rpc.Register(&GossipCore{s})
-
 	l, err := net.Listen("tcp", s.Address)
 	if err != nil {
 		return err
 	}
 
-	go rpc.Accept(l)
+	go s.acceptPeers(l)
 
 	return nil
 }
 
+/*
+ * Serves each incoming connection with its own rpc server, so handlers know
+ * which host they are talking to.
+ */
+func (s *Client) acceptPeers(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Println("Gossip listener stopped:", err)
+			return
+		}
+
+		remote, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+		if err != nil {
+			log.Println(err)
+			conn.Close()
+			continue
+		}
+
+		server := rpc.NewServer()
+		server.Register(&GossipCore{s, remote})
+		go server.ServeConn(conn)
+	}
+}
+
 type RPCHeader struct {
+	Net     uint32
 	Address string
+	Time    time.Time
 }
 
 /*
@@ -49,7 +80,9 @@ type HashMsg struct {
 func (c *Client) NewHashMsg(hash SHA256Sum) HashMsg {
 	return HashMsg{
 		RPCHeader: RPCHeader{
+			Net:     c.Params.Net,
 			Address: c.Address,
+			Time:    time.Now(),
 		},
 		Hash: hash,
 	}
@@ -69,23 +102,29 @@ type HeaderMsg struct {
 
 type BlockMsg struct {
 	RPCHeader
-	Block
+	Block Block
 }
 
 type TxnMsg struct {
 	RPCHeader
-	Txn
+	Txn Txn
 }
 
 type OutputMsg struct {
 	RPCHeader
-	Output
+	Output Output
 }
 
 /*
- * Updates peers with incoming requests
+ * Updates peers with incoming requests from the host `remote`.
  */
-func (c *Client) HandleRPC(request RPCHeader, response *RPCHeader) error {
+func (c *Client) HandleRPC(remote string, request RPCHeader, response *RPCHeader) error {
+	// Refuse peers from other networks
+	if request.Net != c.Params.Net {
+		log.Println("Peer", request.Address, "is on a different network")
+		return errors.New("Network magic mismatch")
+	}
+
 	err := c.PutPeer(request.Address)
 	if err != nil {
 		log.Println(err)
@@ -94,7 +133,14 @@ func (c *Client) HandleRPC(request RPCHeader, response *RPCHeader) error {
 
 	log.Println("Peer Added:", request.Address)
 
+	// Sample peer clock for network-adjusted time
+	if !request.Time.IsZero() {
+		c.TimeSource.AddTimeSample(remote, request.Time)
+	}
+
+	response.Net = c.Params.Net
 	response.Address = c.Address
+	response.Time = time.Now()
 
 	return nil
 }
@@ -103,7 +149,7 @@ func (c *Client) HandleRPC(request RPCHeader, response *RPCHeader) error {
  * Sends the incoming block hash to the client to be resolved.
  */
 func (gc *GossipCore) BcastBlockRPC(req HashMsg, res *RPCHeader) error {
-	err := gc.c.HandleRPC(req.RPCHeader, res)
+	err := gc.c.HandleRPC(gc.remote, req.RPCHeader, res)
 	if err != nil {
 		return err
 	}
@@ -119,7 +165,7 @@ func (gc *GossipCore) BcastBlockRPC(req HashMsg, res *RPCHeader) error {
  * Sends the incoming txn hash to the client to be resolved.
  */
 func (gc *GossipCore) BcastTxnRPC(req HashMsg, res *RPCHeader) error {
-	err := gc.c.HandleRPC(req.RPCHeader, res)
+	err := gc.c.HandleRPC(gc.remote, req.RPCHeader, res)
 	if err != nil {
 		return err
 	}
@@ -159,23 +205,17 @@ func (s *Client) FetchHeader(hash SHA256Sum, address string) (*BlockHeader, erro
 }
 
 func (gc *GossipCore) FetchHeaderRPC(req HashMsg, res *HeaderMsg) error {
-	err := gc.c.HandleRPC(req.RPCHeader, &res.RPCHeader)
-	if err != nil {
-		return err
-	}
-
-	headerBytes, err := gc.c.dbm.headerDB.Get(req.Hash[:], nil)
+	err := gc.c.HandleRPC(gc.remote, req.RPCHeader, &res.RPCHeader)
 	if err != nil {
 		return err
 	}
 
-	var header BlockHeader
-	err = json.Unmarshal(headerBytes, &header)
+	header, err := gc.c.GetHeader(req.Hash)
 	if err != nil {
 		return err
 	}
 
-	res.Header = header
+	res.Header = *header
 
 	return nil
 }
@@ -185,15 +225,26 @@ func (gc *GossipCore) FetchHeaderRPC(req HashMsg, res *HeaderMsg) error {
  */
 
 func (s *Client) FetchBlock(hash SHA256Sum, address string) (*Block, error) {
-	peer, err := s.dialPeer(address)
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
 	if err != nil {
 		return nil, err
 	}
 
+	return s.fetchBlockConn(conn, hash)
+}
+
+/*
+ * Fetches a block over `conn`, refusing to read responses larger than any
+ * valid block.
+ */
+func (s *Client) fetchBlockConn(conn net.Conn, hash SHA256Sum) (*Block, error) {
+	peer := rpc.NewClient(&limitedConn{conn, MAX_BLOCK_SIZE + RPC_OVERHEAD_SIZE})
+	defer peer.Close()
+
 	req := s.NewHashMsg(hash)
 	res := BlockMsg{}
 
-	err = peer.Call("GossipCore.FetchBlockRPC", &req, &res)
+	err := peer.Call("GossipCore.FetchBlockRPC", &req, &res)
 	if err != nil {
 		return nil, err
 	}
@@ -204,6 +255,10 @@ func (s *Client) FetchBlock(hash SHA256Sum, address string) (*Block, error) {
 		return nil, errors.New("Wrong block")
 	}
 
+	if len(block.Txns) > MAX_BLOCK_TXNS || block.Size() > MAX_BLOCK_SIZE {
+		return nil, errors.New("Block too large")
+	}
+
 	if !block.VerifyMerkleHash() {
 		return nil, errors.New("Wrong block")
 	}
@@ -212,7 +267,7 @@ func (s *Client) FetchBlock(hash SHA256Sum, address string) (*Block, error) {
 }
 
 func (gc *GossipCore) FetchBlockRPC(req HashMsg, res *BlockMsg) error {
-	err := gc.c.HandleRPC(req.RPCHeader, &res.RPCHeader)
+	err := gc.c.HandleRPC(gc.remote, req.RPCHeader, &res.RPCHeader)
 	if err != nil {
 		return err
 	}
@@ -255,7 +310,7 @@ func (c *Client) FetchTxn(hash SHA256Sum, address string) (*Txn, error) {
 }
 
 func (gc *GossipCore) FetchTxnRPC(req HashMsg, res *TxnMsg) error {
-	err := gc.c.HandleRPC(req.RPCHeader, &res.RPCHeader)
+	err := gc.c.HandleRPC(gc.remote, req.RPCHeader, &res.RPCHeader)
 	if err != nil {
 		return err
 	}
@@ -298,7 +353,7 @@ func (c *Client) FetchOutput(hash SHA256Sum, address string) (*Output, error) {
 }
 
 func (gc *GossipCore) FetchOutputRPC(req HashMsg, res *OutputMsg) error {
-	err := gc.c.HandleRPC(req.RPCHeader, &res.RPCHeader)
+	err := gc.c.HandleRPC(gc.remote, req.RPCHeader, &res.RPCHeader)
 	if err != nil {
 		return err
 	}
@@ -325,6 +380,30 @@ func (s *Client) dialPeer(address string) (*rpc.Client, error) {
 	return rpc.NewClient(conn), nil
 }
 
+/*
+ * A connection whose reads may total at most `remaining` bytes.  Reads past the
+ * limit fail, so oversized messages are never decoded.
+ */
+type limitedConn struct {
+	net.Conn
+	remaining int64
+}
+
+func (lc *limitedConn) Read(p []byte) (int, error) {
+	if lc.remaining <= 0 {
+		return 0, errors.New("Response exceeds size limit")
+	}
+
+	if int64(len(p)) > lc.remaining {
+		p = p[:lc.remaining]
+	}
+
+	n, err := lc.Conn.Read(p)
+	lc.remaining -= int64(n)
+
+	return n, err
+}
+
 func (c *Client) sendBcast(method, address string, hash SHA256Sum) {
 	peer, err := c.dialPeer(address)
 	if err != nil {
@@ -0,0 +1,142 @@
+package ozcoin
+
+import (
+	"net"
+	"net/rpc"
+	"sync/atomic"
+	"testing"
+)
+
+/*
+ * A peer that answers every block request with `block`, regardless of limits.
+ */
+type testBlockPeer struct {
+	block Block
+}
+
+func (p *testBlockPeer) FetchBlockRPC(req HashMsg, res *BlockMsg) error {
+	res.Block = p.block
+	return nil
+}
+
+/*
+ * Counts the bytes the peer manages to write.  Pipes are unbuffered, so these
+ * are exactly the bytes read on the other end.
+ */
+type countingConn struct {
+	net.Conn
+	written int64
+}
+
+func (cc *countingConn) Write(p []byte) (int, error) {
+	n, err := cc.Conn.Write(p)
+	atomic.AddInt64(&cc.written, int64(n))
+
+	return n, err
+}
+
+/*
+ * Fetches `block` from a peer serving it over a pipe, also returning the number
+ * of bytes the peer sent.
+ */
+func fetchTestBlock(t *testing.T, c *Client, block Block) (*Block, int64, error) {
+	server := rpc.NewServer()
+	err := server.RegisterName("GossipCore", &testBlockPeer{block})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local, remote := net.Pipe()
+	counted := &countingConn{Conn: remote}
+
+	done := make(chan struct{})
+	go func() {
+		server.ServeConn(counted)
+		close(done)
+	}()
+
+	fetched, err := c.fetchBlockConn(local, block.Header.Hash())
+	<-done
+
+	return fetched, atomic.LoadInt64(&counted.written), err
+}
+
+func TestFetchBlock(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := mineTestBlock(c, c.LastHeader, NewPrivateKey().PublicKey(), nil)
+	fetched, _, err := fetchTestBlock(t, c, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fetched.Header.Hash() != block.Header.Hash() {
+		t.Error("Fetched the wrong block")
+	}
+}
+
+func TestFetchOversizedBlock(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := mineTestBlock(c, c.LastHeader, NewPrivateKey().PublicKey(), nil)
+	coinbase := block.Txns[0]
+
+	// Well past the limit, but with fewer than MAX_BLOCK_TXNS txns
+	limit := int64(MAX_BLOCK_SIZE + RPC_OVERHEAD_SIZE)
+	for n := 2 * limit / int64(coinbase.Size()); n > 0; n-- {
+		block.Txns = append(block.Txns, coinbase)
+	}
+	if len(block.Txns) > MAX_BLOCK_TXNS {
+		t.Fatal("Oversized block has too many txns:", len(block.Txns))
+	}
+	block.Header.MerkleRoot = block.MerkleHash()
+
+	_, sent, err := fetchTestBlock(t, c, block)
+	if err == nil {
+		t.Fatal("Oversized block accepted")
+	}
+
+	if sent > limit {
+		t.Errorf("Read %d bytes of an oversized block, limit is %d", sent, limit)
+	}
+}
+
+func TestFetchBlockTooManyTxns(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := mineTestBlock(c, c.LastHeader, NewPrivateKey().PublicKey(), nil)
+
+	// Txns without outputs keep the block well within the size limit
+	empty := block.Txns[0]
+	empty.Body.Outputs = nil
+	for len(block.Txns) <= MAX_BLOCK_TXNS {
+		block.Txns = append(block.Txns, empty)
+	}
+	if block.Size() > MAX_BLOCK_SIZE {
+		t.Fatal("Block of empty txns exceeds MAX_BLOCK_SIZE")
+	}
+	block.Header.MerkleRoot = block.MerkleHash()
+
+	_, _, err = fetchTestBlock(t, c, block)
+	if err == nil {
+		t.Error("Block with too many txns accepted")
+	}
+}
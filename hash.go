@@ -24,19 +24,49 @@ func (h SHA256Sum) Int() *big.Int {
 	return i
 }
 
+/*
+ * Hash to point
+ *
+ * Key preimages are `sk * HashToPt(pk)`, so the discrete log of the base point
+ * must be unknown.  The legacy map `Hash(data) * G` leaked it, and is only kept
+ * so signatures over outputs created before a network's `HashToPtHeight` still
+ * verify.
+ */
+
+const (
+	HASH_TO_PT_LEGACY        uint8 = 0
+	HASH_TO_PT_TRY_INCREMENT uint8 = 1
+)
+
+/*
+ * Maps data to a point with unknown discrete log by the curve's
+ * try-and-increment.
+ */
 func HashToPt(data []byte) ECCPoint {
-	// Replace with ECC Pt Decoding
+	return CURVE.HashToPt(data)
+}
+
+/*
+ * The original map, whose discrete log is `Hash(data)`.
+ */
+func legacyHashToPt(data []byte) ECCPoint {
 	h := Hash(data)
-	x, y := CURVE.Params().ScalarBaseMult(h[:])
 
-	return ECCPoint{x, y}
+	return CURVE.BaseMult(h[:])
+}
+
+func hashToPtVersion(version uint8, data []byte) ECCPoint {
+	if version == HASH_TO_PT_LEGACY {
+		return legacyHashToPt(data)
+	}
+
+	return HashToPt(data)
 }
 
 func HashPt(m []byte, p ECCPoint) SHA256Sum {
 	data := []byte{}
 	data = append(data, m...)
-	data = append(data, p.X.Bytes()...)
-	data = append(data, p.Y.Bytes()...)
+	data = append(data, p.Bytes()...)
 
 	return sha256.Sum256(data)
 }
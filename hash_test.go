@@ -0,0 +1,61 @@
+package ozcoin
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestHashToPtVectors(t *testing.T) {
+	vectors := []struct {
+		data string
+		x, y string
+	}{
+		{
+			"",
+			"1626f87397394d2957bc8b7d0f6325f43783bb423db0bf9ca3d83efbc6c8c5c8",
+			"7c69345591c19b13b86e2f6c036b38d766e66ba6f9dc0e3d238c2dfdf3a9237e",
+		},
+		{
+			"616263", // "abc"
+			"b1f0467fe3d38fb20165a9e525c693e3f01895e6eb9b9db9e4f93d8131cec18b",
+			"4c13d70a3812d32170e3939ee1b19ca05dc86a177be121332afcb344a0f56ce6",
+		},
+		{
+			"036b17d1f2e12c4247f8bce6e563a440f277037d812deb33a0f4a13945d898c296", // G
+			"09536e956a13d3d58c4f65092335fd5c8744c45be71469c3fdf04fb238e7fe90",
+			"83c9a2a4202bf39f5a9e34962f71498e982da6aead1c3366a6e3918a457fdae4",
+		},
+	}
+
+	for _, v := range vectors {
+		data, err := hex.DecodeString(v.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p := HashToPt(data)
+		if !p.Valid() {
+			t.Fatal("Hashed point not on curve")
+		}
+
+		if p.X.Cmp(hexInt(v.x)) != 0 || p.Y.Cmp(hexInt(v.y)) != 0 {
+			t.Errorf("HashToPt(%s) = (%x, %x)", v.data, p.X, p.Y)
+		}
+	}
+}
+
+func TestLegacyHashToPt(t *testing.T) {
+	data := []byte("abc")
+	h := Hash(data)
+	hG := CURVE.BaseMult(h[:])
+
+	legacy := hashToPtVersion(HASH_TO_PT_LEGACY, data)
+	if legacy.X.Cmp(hG.X) != 0 || legacy.Y.Cmp(hG.Y) != 0 {
+		t.Error("Legacy hash to point changed")
+	}
+
+	secure := hashToPtVersion(HASH_TO_PT_TRY_INCREMENT, data)
+	if secure.X.Cmp(hG.X) == 0 {
+		t.Error("Secure hash to point has a known discrete log")
+	}
+}
@@ -1,17 +1,53 @@
 package ozcoin
 
 import (
+	"errors"
 	"log"
+	"math"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	BLOCK_UPDATE_INTERVAL = 30 * time.Second
+	HASH_RATE_INTERVAL    = time.Minute
+	CANCEL_CHECK_INTERVAL = 1 << 12 // nonces between checks for cancellation
+)
+
+/*
+ * Miner
+ *
+ * Searches for proof of work on top of the main chain.  The nonce space is
+ * split across `Threads` workers.
+ */
 type Miner struct {
 	*Client
+	threads  int32
+	hashes   uint64
+	hashRate uint64
+}
+
+/*
+ * Builds a miner that continuously mines on top of the main chain.
+ */
+func NewMiner(params *ChainParams, miningAddr, walletAddr string, password string) *Miner {
+	m := NewIdleMiner(params, miningAddr, walletAddr, password)
+
+	go m.run()
+
+	return m
 }
 
-func NewMiner(miningAddr, walletAddr string, password string) *Miner {
+/*
+ * Builds a miner that only mines on demand through `Generate`.
+ */
+func NewIdleMiner(params *ChainParams, miningAddr, walletAddr string, password string) *Miner {
 	m := &Miner{
-		Client: NewBlockchain(miningAddr, walletAddr, password),
+		Client:  NewBlockchain(params, miningAddr, walletAddr, password),
+		threads: int32(runtime.NumCPU()),
 	}
 
 	err := m.Client.Wallet.OpenWallet(password)
@@ -20,70 +56,258 @@ func NewMiner(miningAddr, walletAddr string, password string) *Miner {
 		panic("Could not authenticate wallet")
 	}
 
-	go m.run()
+	// Serve block templates on the mining host
+	host, _, err := net.SplitHostPort(miningAddr)
+	if err != nil {
+		log.Println(err)
+		panic("Invalid mining address")
+	}
+	m.ServeMining(net.JoinHostPort(host, params.TemplatePort))
 
 	return m
 }
 
+/*
+ * The wallet address that receives coinbase rewards.
+ */
+func (m *Miner) MiningAddress() (WalletPublicKey, error) {
+	addresses, err := m.Wallet.TrackingKeys()
+	if err != nil {
+		return WalletPublicKey{}, err
+	}
+
+	if len(addresses) == 0 {
+		return WalletPublicKey{}, errors.New("Wallet has no addresses")
+	}
+
+	return addresses[0].PublicKey(), nil
+}
+
+/*
+ * Sets the number of worker goroutines, taking effect on the next block.
+ */
+func (m *Miner) SetThreads(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	atomic.StoreInt32(&m.threads, int32(n))
+}
+
+func (m *Miner) Threads() int {
+	n := atomic.LoadInt32(&m.threads)
+	if n < 1 {
+		return 1
+	}
+
+	return int(n)
+}
+
+/*
+ * Hashes per second over the last `HASH_RATE_INTERVAL`.
+ */
+func (m *Miner) HashRate() uint64 {
+	return atomic.LoadUint64(&m.hashRate)
+}
+
+/*
+ * Mines `n` blocks on top of the main chain, paying each coinbase to `address`,
+ * and returns their hashes.  Blocks are built like `NewBlock` within the
+ * client's run loop, and each is adopted through `BlockChan` before the next
+ * one is built.
+ */
+func (m *Miner) Generate(n int, address WalletPublicKey) ([]SHA256Sum, error) {
+	hashes := []SHA256Sum{}
+	for i := 0; i < n; i++ {
+		block := m.RequestBlockTemplate().Block(address)
+		if block.Header.Bits == 0 {
+			return hashes, errors.New("Unable to compute difficulty")
+		}
+
+		block, _ = m.Solve(block, nil)
+
+		err := m.SubmitBlock(block)
+		if err != nil {
+			return hashes, err
+		}
+
+		hashes = append(hashes, block.Header.Hash())
+	}
+
+	return hashes, nil
+}
+
+/*
+ * Searches for a valid nonce using `Threads` workers.  Each worker owns an
+ * equal slice of the nonce space, and bumps the coinbase extra-nonce whenever
+ * its slice runs out.  Returns false if `cancel` closes first.
+ */
+func (m *Miner) Solve(block Block, cancel <-chan struct{}) (Block, bool) {
+	threads := m.Threads()
+
+	found := make(chan Block, threads)
+	stop := make(chan struct{})
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		start, end := nonceRange(i, threads)
+		go func() {
+			defer wg.Done()
+			m.searchNonces(block, start, end, found, stop)
+		}()
+	}
+
+	var solved Block
+	ok := true
+	select {
+	case solved = <-found:
+	case <-cancel:
+		ok = false
+	}
+
+	close(stop)
+	wg.Wait()
+
+	return solved, ok
+}
+
+/*
+ * The slice [start, end) of the nonce space searched by `worker` out of
+ * `threads`.  The last worker's slice runs to the end of the nonce space.
+ */
+func nonceRange(worker, threads int) (uint64, uint64) {
+	span := math.MaxUint64 / uint64(threads)
+	start := uint64(worker) * span
+	if worker == threads-1 {
+		return start, math.MaxUint64
+	}
+
+	return start, start + span
+}
+
+/*
+ * Tries nonces in [start, end) for every extra-nonce until a solution is found
+ * or `stop` closes.  Since nonce ranges never overlap, workers may reuse the
+ * same extra-nonces.
+ */
+func (m *Miner) searchNonces(block Block, start, end uint64, found chan<- Block, stop <-chan struct{}) {
+	// Work on a private copy of the coinbase
+	block.Txns = append([]Txn{}, block.Txns...)
+
+	for extraNonce := block.Txns[0].Body.ExtraNonce; ; extraNonce++ {
+		block.Txns[0].Body.ExtraNonce = extraNonce
+		block.Header.MerkleRoot = block.MerkleHash()
+
+		count := uint64(0)
+		for nonce := start; nonce < end; nonce++ {
+			count++
+			if count == CANCEL_CHECK_INTERVAL {
+				atomic.AddUint64(&m.hashes, count)
+				count = 0
+
+				select {
+				case <-stop:
+					return
+				default:
+				}
+			}
+
+			block.Header.Nonce = nonce
+			if block.Header.ValidPoW() {
+				atomic.AddUint64(&m.hashes, count)
+				found <- block
+				return
+			}
+		}
+		atomic.AddUint64(&m.hashes, count)
+	}
+}
+
+/*
+ * Periodically records and logs the hash rate.
+ */
+func (m *Miner) trackHashRate() {
+	ticker := time.NewTicker(HASH_RATE_INTERVAL)
+	for range ticker.C {
+		hashes := atomic.SwapUint64(&m.hashes, 0)
+		rate := hashes / uint64(HASH_RATE_INTERVAL/time.Second)
+		atomic.StoreUint64(&m.hashRate, rate)
+
+		log.Println("Hash rate:", rate, "H/s on", m.Threads(), "threads")
+	}
+}
+
 func (m *Miner) run() {
 	log.Println("Running miner...")
 
-	addresses, err := m.Wallet.TrackingKeys()
+	miningAddress, err := m.MiningAddress()
 	if err != nil {
 		panic("Could not load addresses from wallet")
 	}
-	miningAddress := addresses[0].PublicKey()
 
-	gblock := GenesisBlock(miningAddress)
-	err = m.WriteBlock(gblock)
-	if err != nil {
-		panic("Could not add genesis block")
-	}
+	go m.trackHashRate()
 
-	updateTime := time.After(30 * time.Second)
-	recentlyUpdated := false
+	tipChan := m.SubscribeTip()
 
-	m.LastHeader = gblock.Header
+	// The last block this miner had adopted, whose tip signal is stale
+	mined := SHA256Sum{}
 
-	last := m.LastHeader.Hash()
-	block := m.NewBlock(m.LastHeader, miningAddress)
+	updateTime := time.After(BLOCK_UPDATE_INTERVAL)
 	for {
-		select {
-		case now := <-updateTime:
-			log.Println("Updating block time")
-			if !recentlyUpdated {
-				block.Header.Time = now
-				block.Header.Difficulty = m.ComputeDifficulty(block)
+		block := m.RequestBlockTemplate().Block(miningAddress)
+		log.Println("Mining at height", block.Header.SeqNum)
+
+		cancel := make(chan struct{})
+		solved := make(chan Block, 1)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			b, ok := m.Solve(block, cancel)
+			if ok {
+				solved <- b
 			}
+		}()
 
-			updateTime = time.After(30 * time.Second)
-			recentlyUpdated = false
+		for mining := true; mining; {
+			select {
+			case b := <-solved:
+				mining = false
 
-			// Sign request for testing
-			_, err = m.Wallet.SignTxn(&miningAddress, 1, 1)
-			if err != nil {
-				log.Println(err)
-			}
+				// Inform self
+				log.Println("Block found")
+				err = m.SubmitBlock(b)
+				if err != nil {
+					log.Println("Block rejected:", err)
+					continue
+				}
+				mined = b.Header.Hash()
 
-		default:
-			if last != m.LastHeader.Hash() {
-				log.Println("Building new block")
-				// Create new block
-				last = m.LastHeader.Hash()
-				block = m.NewBlock(m.LastHeader, miningAddress)
-				recentlyUpdated = true
-			} else {
-				// Increment nonce
-				block.Header.Nonce += 1
-			}
+			case hash := <-tipChan:
+				// The template already extends our own block
+				if hash == mined {
+					continue
+				}
+				mining = false
 
-			if !block.Header.ValidPoW() {
-				continue
-			}
+				log.Println("New tip, rebuilding block")
+				close(cancel)
+				<-done
+
+			case <-updateTime:
+				mining = false
 
-			// Inform self
-			log.Println("Block found")
-			m.BlockChan <- block
+				log.Println("Updating block time")
+				close(cancel)
+				<-done
+				updateTime = time.After(BLOCK_UPDATE_INTERVAL)
+
+				// Sign request for testing
+				_, err = m.Wallet.SignTxn(&miningAddress, 1, 1)
+				if err != nil {
+					log.Println(err)
+				}
+			}
 		}
 	}
 }
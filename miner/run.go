@@ -3,18 +3,71 @@ package main
 import (
 	"github.com/cfromknecht/ozcoin"
 
+	"flag"
+	"fmt"
 	"log"
+	"runtime"
 )
 
 func main() {
-	miningAddress := "127.0.0.1:6000"
-	walletAddress := "127.0.0.1:6002"
+	net := flag.String("net", "mainnet", "network to join: mainnet, testnet, regtest, or regtest-secp256k1")
+	generate := flag.Int("generate", 0, "mine this many blocks to the wallet, print their hashes, and exit")
+	threads := flag.Int("threads", runtime.NumCPU(), "number of mining goroutines")
+	stratum := flag.Bool("stratum", false, "serve pool workers over stratum instead of mining locally")
+	flag.Parse()
+
+	params, err := ozcoin.ParamsForNet(*net)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	miningAddress := "127.0.0.1:" + params.MinerPort
+	walletAddress := "127.0.0.1:" + params.WalletPort
 	password := "test"
 
-	miner := ozcoin.NewMiner(miningAddress, walletAddress, password)
+	if *generate > 0 {
+		miner := ozcoin.NewIdleMiner(params, miningAddress, walletAddress, password)
+		miner.SetThreads(*threads)
+
+		address, err := miner.MiningAddress()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		hashes, err := miner.Generate(*generate, address)
+		for _, hash := range hashes {
+			fmt.Printf("%x\n", hash)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	if *stratum {
+		miner := ozcoin.NewIdleMiner(params, miningAddress, walletAddress, password)
+
+		address, err := miner.MiningAddress()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		server := ozcoin.NewStratumServer(miner, "127.0.0.1:"+params.StratumPort, address)
+		err = server.Start()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		doneChan := make(chan []struct{})
+		<-doneChan
+	}
+
+	miner := ozcoin.NewMiner(params, miningAddress, walletAddress, password)
 	if miner == nil {
 		log.Println("Could not create miner")
 	}
+	miner.SetThreads(*threads)
 
 	doneChan := make(chan []struct{})
 	<-doneChan
@@ -0,0 +1,146 @@
+package ozcoin
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNonceRange(t *testing.T) {
+	for _, threads := range []int{1, 2, 3, 7} {
+		next := uint64(0)
+		for i := 0; i < threads; i++ {
+			start, end := nonceRange(i, threads)
+			if start != next {
+				t.Fatalf("Worker %d of %d starts at %d, expected %d", i, threads, start, next)
+			}
+			if end <= start {
+				t.Fatalf("Worker %d of %d has an empty range", i, threads)
+			}
+			next = end
+		}
+
+		if next != math.MaxUint64 {
+			t.Errorf("%d workers stop short of the nonce space at %d", threads, next)
+		}
+	}
+}
+
+func TestSolve(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Miner{Client: c, threads: 4}
+	block := c.NewBlock(c.LastHeader, NewPrivateKey().PublicKey())
+
+	solved, ok := m.Solve(block, nil)
+	if !ok {
+		t.Fatal("Solve cancelled without a cancel channel")
+	}
+	if !solved.Header.ValidPoW() || !solved.VerifyMerkleHash() {
+		t.Fatal("Solved block is invalid")
+	}
+	if !c.PrevalidBlock(solved) {
+		t.Error("Solved block failed prevalidation")
+	}
+
+	// Regtest solutions are found within the first few nonces of some worker
+	inRange := false
+	for i := 0; i < m.Threads(); i++ {
+		start, _ := nonceRange(i, m.Threads())
+		if solved.Header.Nonce-start < CANCEL_CHECK_INTERVAL {
+			inRange = true
+		}
+	}
+	if !inRange {
+		t.Error("Nonce not near the start of any worker's range:", solved.Header.Nonce)
+	}
+
+	// Mining a header that can not be solved stops on cancel
+	block.Header.Bits = 0x03000001
+	cancel := make(chan struct{})
+	close(cancel)
+	_, ok = m.Solve(block, cancel)
+	if ok {
+		t.Error("Solve ignored cancel")
+	}
+}
+
+func TestSolveExtraNonceRollover(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Miner{Client: c, threads: 1}
+	block := c.NewBlock(c.LastHeader, NewPrivateKey().PublicKey())
+
+	// Start from an extra-nonce whose only nonce fails
+	block.Header.Nonce = 0
+	for block.Header.ValidPoW() {
+		block.Txns[0].Body.ExtraNonce++
+		block.Header.MerkleRoot = block.MerkleHash()
+	}
+	extraNonce := block.Txns[0].Body.ExtraNonce
+
+	found := make(chan Block, 1)
+	m.searchNonces(block, 0, 1, found, make(chan struct{}))
+
+	solved := <-found
+	if solved.Txns[0].Body.ExtraNonce <= extraNonce {
+		t.Error("Extra-nonce not bumped after exhausting the nonce range")
+	}
+	if solved.Header.Nonce != 0 {
+		t.Error("Nonce outside of the searched range:", solved.Header.Nonce)
+	}
+	if !solved.Header.ValidPoW() || !solved.VerifyMerkleHash() {
+		t.Error("Solved block is invalid")
+	}
+
+	// The caller's coinbase is untouched
+	if block.Txns[0].Body.ExtraNonce != extraNonce {
+		t.Error("searchNonces modified the caller's coinbase")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go c.run()
+
+	m := &Miner{Client: c, threads: 2}
+	hashes, err := m.Generate(5, NewPrivateKey().PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(hashes) != 5 {
+		t.Fatal("Expected 5 blocks, got", len(hashes))
+	}
+	if c.LastHeader.SeqNum != 5 || c.LastHeader.Hash() != hashes[4] {
+		t.Error("Last generated block is not the tip")
+	}
+
+	for i, hash := range hashes {
+		mainHash, err := c.GetHeightHash(uint64(i) + 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mainHash != hash {
+			t.Errorf("Block %d not on the main chain", i+1)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package ozcoin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+/*
+ * BlockTemplate
+ *
+ * Everything an external miner needs to build and solve the next block.  The
+ * miner adds its own coinbase for `CoinbaseValue`, computes the merkle root,
+ * and searches for a nonce whose hash is no greater than `Target`.
+ */
+type BlockTemplate struct {
+	SeqNum        uint64    `json:"seq_num"`
+	PrevHash      SHA256Sum `json:"prev_hash"`
+	Time          time.Time `json:"time"`
+	MinTime       time.Time `json:"min_time"`
+	Bits          uint32    `json:"bits"`
+	Target        string    `json:"target"`
+	CoinbaseValue uint64    `json:"coinbase_value"`
+	Txns          []Txn     `json:"txns"`
+}
+
+/*
+ * Selects pool txns and computes the header fields for a block extending
+ * `prev`.
+ */
+func (c *Client) NewBlockTemplate(prev BlockHeader) BlockTemplate {
+	seqNum := prev.SeqNum + 1
+
+	// Block time must come after the median-time-past, headers only encode
+	// whole seconds
+	now := time.Now().Truncate(time.Second)
+	minTime := now
+	mtp, err := c.MedianTimePast(prev.Hash())
+	if err == nil {
+		minTime = mtp.Add(time.Second)
+		if now.Before(minTime) {
+			now = minTime
+		}
+	}
+
+	// Gather the best paying txns that fit and add fees
+	txns := SelectTxns(c.TxnsFromPool(), MAX_BLOCK_SIZE-BLOCK_RESERVED_SIZE,
+		MAX_BLOCK_TXNS-1)
+	fees := uint64(0)
+	for _, t := range txns {
+		fees += t.Body.Fee
+	}
+
+	// Only the sequence and parent matter for difficulty
+	bits := c.ComputeDifficulty(Block{
+		Header: BlockHeader{
+			SeqNum:   seqNum,
+			PrevHash: prev.Hash(),
+		},
+	})
+
+	return BlockTemplate{
+		SeqNum:        seqNum,
+		PrevHash:      prev.Hash(),
+		Time:          now,
+		MinTime:       minTime,
+		Bits:          bits,
+		Target:        fmt.Sprintf("%064x", CompactToBig(bits)),
+		CoinbaseValue: c.Params.CoinbaseValue(seqNum) + fees,
+		Txns:          txns,
+	}
+}
+
+/*
+ * TemplateRequest
+ *
+ * Asks the client's run loop for a template extending the main chain tip, so
+ * the tip, txn pool and databases are never read mid reorg.
+ */
+type TemplateRequest struct {
+	Result chan BlockTemplate
+}
+
+/*
+ * Builds a template for the next block on the main chain and waits for it.
+ */
+func (c *Client) RequestBlockTemplate() BlockTemplate {
+	req := TemplateRequest{
+		Result: make(chan BlockTemplate, 1),
+	}
+
+	c.TemplateChan <- req
+
+	return <-req.Result
+}
+
+/*
+ * Builds a requested template between other chain operations.
+ */
+func (c *Client) ServeTemplateRequest(req TemplateRequest, startChan chan struct{}, doneChan chan SHA256Sum) {
+	_ = <-startChan
+
+	// Signal when complete, nothing was added to the frontier
+	defer func() { doneChan <- SHA256Sum{} }()
+
+	req.Result <- c.NewBlockTemplate(c.LastHeader)
+}
+
+/*
+ * Builds an unsolved block from the template, paying the coinbase to
+ * `address`.
+ */
+func (t BlockTemplate) Block(address WalletPublicKey) Block {
+	coinbaseTxn := NewCoinbaseTxn(address, t.CoinbaseValue)
+
+	block := Block{
+		Header: BlockHeader{
+			SeqNum:     t.SeqNum,
+			PrevHash:   t.PrevHash,
+			MerkleRoot: SHA256Sum{},
+			Time:       t.Time,
+			Bits:       t.Bits,
+			Nonce:      0,
+		},
+		Txns: []Txn{
+			coinbaseTxn,
+		},
+	}
+
+	block.Txns = append(block.Txns, t.Txns...)
+
+	block.Header.MerkleRoot = block.MerkleHash()
+
+	return block
+}
+
+type SubmitBlockMsg struct {
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+/*
+ * Starts an http server exposing `/getblocktemplate` and `/submitblock` to
+ * external mining software.
+ */
+func (c *Client) ServeMining(address string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/getblocktemplate", c.handleGetBlockTemplate)
+	mux.HandleFunc("/submitblock", c.handleSubmitBlock)
+
+	log.Println("Mining server listening", address)
+
+	go func() {
+		err := http.ListenAndServe(address, mux)
+		if err != nil {
+			log.Println("Mining server stopped:", err)
+		}
+	}()
+}
+
+/*
+ * Route handlers
+ */
+
+func (c *Client) handleGetBlockTemplate(w http.ResponseWriter, r *http.Request) {
+	tmpl := c.RequestBlockTemplate()
+	if tmpl.Bits == 0 {
+		http.Error(w, "Unable to compute difficulty", http.StatusInternalServerError)
+		return
+	}
+
+	jsonWrite(w, tmpl)
+}
+
+func (c *Client) handleSubmitBlock(w http.ResponseWriter, r *http.Request) {
+	decoder := json.NewDecoder(r.Body)
+	var block Block
+	err := decoder.Decode(&block)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Round trip through the canonical encoding, which hashes must commit to
+	data, err := block.MarshalBinary()
+	if err == nil {
+		block = Block{}
+		err = block.UnmarshalBinary(data)
+	}
+	if err != nil {
+		http.Error(w, "Block not encodable: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res := SubmitBlockMsg{
+		Accepted: true,
+	}
+
+	err = c.SubmitBlock(block)
+	if err != nil {
+		res.Accepted = false
+		res.Reason = err.Error()
+	}
+
+	jsonWrite(w, res)
+}
@@ -0,0 +1,125 @@
+package ozcoin
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+/*
+ * Solves a block from a template fetched through `/getblocktemplate`.
+ */
+func solveTestTemplate(t *testing.T, c *Client) Block {
+	rec := httptest.NewRecorder()
+	c.handleGetBlockTemplate(rec, httptest.NewRequest("GET", "/getblocktemplate", nil))
+
+	var tmpl BlockTemplate
+	err := json.Unmarshal(rec.Body.Bytes(), &tmpl)
+	if err != nil {
+		t.Fatal(err, rec.Body.String())
+	}
+
+	if tmpl.PrevHash != c.LastHeader.Hash() || tmpl.SeqNum != c.LastHeader.SeqNum+1 {
+		t.Fatal("Template does not extend the tip")
+	}
+
+	block := tmpl.Block(NewPrivateKey().PublicKey())
+	for !block.Header.ValidPoW() {
+		block.Header.Nonce++
+	}
+
+	return block
+}
+
+func submitTestBlock(t *testing.T, c *Client, block Block) SubmitBlockMsg {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/submitblock", bytes.NewReader(block.Json()))
+	c.handleSubmitBlock(rec, req)
+
+	var res SubmitBlockMsg
+	err := json.Unmarshal(rec.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err, rec.Body.String())
+	}
+
+	return res
+}
+
+func TestGetBlockTemplate(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go c.run()
+
+	tip := c.LastHeader
+	rec := httptest.NewRecorder()
+	c.handleGetBlockTemplate(rec, httptest.NewRequest("GET", "/getblocktemplate", nil))
+
+	var tmpl BlockTemplate
+	err = json.Unmarshal(rec.Body.Bytes(), &tmpl)
+	if err != nil {
+		t.Fatal(err, rec.Body.String())
+	}
+
+	if tmpl.SeqNum != tip.SeqNum+1 || tmpl.PrevHash != tip.Hash() {
+		t.Error("Template does not extend the tip")
+	}
+	if tmpl.Bits != c.Params.PowLimitBits {
+		t.Errorf("Expected bits %08x, got %08x", c.Params.PowLimitBits, tmpl.Bits)
+	}
+	if tmpl.CoinbaseValue != c.Params.CoinbaseValue(tmpl.SeqNum) {
+		t.Error("Unexpected coinbase value:", tmpl.CoinbaseValue)
+	}
+	if !tmpl.Time.After(tip.Time) {
+		t.Error("Template time not after median-time-past")
+	}
+}
+
+func TestSubmitBlock(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go c.run()
+
+	block := solveTestTemplate(t, c)
+
+	// A block on the wrong parent is rejected with a reason
+	orphan := block
+	orphan.Header.PrevHash = SHA256Sum{1}
+	res := submitTestBlock(t, c, orphan)
+	if res.Accepted || res.Reason == "" {
+		t.Errorf("Block on unknown parent accepted: %+v", res)
+	}
+
+	res = submitTestBlock(t, c, block)
+	if !res.Accepted {
+		t.Fatal("Solved block rejected:", res.Reason)
+	}
+
+	if c.LastHeader.Hash() != block.Header.Hash() {
+		t.Error("Accepted block not adopted as tip")
+	}
+
+	// The same block no longer extends the tip
+	res = submitTestBlock(t, c, block)
+	if res.Accepted {
+		t.Error("Stale block accepted")
+	}
+
+	// Templates follow the new tip
+	next := solveTestTemplate(t, c)
+	if next.Header.SeqNum != block.Header.SeqNum+1 {
+		t.Error("Template did not follow the new tip")
+	}
+}
@@ -22,6 +22,13 @@ type Output struct {
 	Commit    Commitment `json:"commit"`
 }
 
+/*
+ * Coinbase outputs, and only coinbase outputs, have a zero blind seed.
+ */
+func (o Output) IsCoinbase() bool {
+	return o.BlindSeed.IsIdentity()
+}
+
 /*
  * Returns json bytes.
  */
@@ -36,10 +43,10 @@ func (o Output) Json() []byte {
 }
 
 /*
- * Returns hash of json bytes.
+ * Returns hash of the canonical encoding.
  */
 func (o Output) Hash() SHA256Sum {
-	return Hash(o.Json())
+	return Hash(o.Bytes())
 }
 
 /*
@@ -83,17 +90,14 @@ func (o *Output) Decrypt(addr WalletPrivateKey) *OutputPlaintext {
  */
 func (o Output) DecryptAmount(yOut *big.Int) (uint64, error) {
 	total := uint64(0)
-	zero := &big.Int{}
 
 	pks := o.Commit.RangeProof.PKs
 	for i, blind := range ComputeBlinds(yOut) {
-		rGx, rGy := CURVE.Params().ScalarBaseMult(blind.Bytes())
-		rGy.Neg(rGy)
+		rG := CURVE.BaseMult(blind.Bytes()).Neg()
 
 		success := false
 		for j, pk := range pks[i] {
-			x, y := CURVE.Params().Add(pk.X, pk.Y, rGx, rGy)
-			if zero.Cmp(x) == 0 && zero.Cmp(y) == 0 {
+			if CURVE.Add(pk, rG).IsIdentity() {
 				include := uint64(1 - j)
 				total += (uint64(1) << uint64(i)) * include
 				success = true
@@ -124,8 +128,7 @@ func (o Output) ComputeBlindingFactor(addr WalletPrivateKey) *big.Int {
 	}
 
 	psk := addr.PSK
-	bQx, bQy := CURVE.Params().ScalarMult(Q.X, Q.Y, psk.Bytes())
-	blind := Hash(ECCPoint{bQx, bQy}.Bytes())
+	blind := Hash(CURVE.Mult(Q, psk.Bytes()).Bytes())
 
 	return blind.Int()
 }
@@ -138,10 +141,9 @@ func (o Output) BelongsToMe(addr WalletTrackingKey) bool {
 	ppk := addr.PPK
 
 	h := o.HashSharedSecret(addr)
-	dkx, dky := CURVE.Params().ScalarBaseMult(h.Bytes())
-	dkx, dky = CURVE.Params().Add(dkx, dky, ppk.X, ppk.Y)
+	dk := CURVE.Add(CURVE.BaseMult(h.Bytes()), ppk)
 
-	return dkx.Cmp(o.DestKey.X) == 0 && dky.Cmp(o.DestKey.Y) == 0
+	return dk.X.Cmp(o.DestKey.X) == 0 && dk.Y.Cmp(o.DestKey.Y) == 0
 }
 
 /*
@@ -153,8 +155,8 @@ func (o Output) ComputeTxnPrivateKey(addr WalletPrivateKey) *big.Int {
 	x.SetBytes(h.Bytes())
 	x.Add(x, addr.PSK)
 
-	dkx, dky := CURVE.Params().ScalarBaseMult(x.Bytes())
-	log.Println("xG:", dkx, dky)
+	dk := CURVE.BaseMult(x.Bytes())
+	log.Println("xG:", dk.X, dk.Y)
 
 	return x
 }
@@ -166,9 +168,7 @@ func (o Output) ComputeTxnPrivateKey(addr WalletPrivateKey) *big.Int {
 func (o Output) HashSharedSecret(addr WalletTrackingKey) SHA256Sum {
 	R := o.PublicKey
 	tsk := addr.TSK
-	aRx, aRy := CURVE.Params().ScalarMult(R.X, R.Y, tsk.Bytes())
-
-	return Hash(ECCPoint{aRx, aRy}.Bytes())
+	return Hash(CURVE.Mult(R, tsk.Bytes()).Bytes())
 }
 
 /*
@@ -217,10 +217,16 @@ func (c *Client) FindOutput(hash SHA256Sum) (*Output, error) {
 		address := string(iter.Key())
 		output, err := c.FetchOutput(hash, address)
 		if err == nil {
+			iter.Release()
 			return output, nil
 		}
 	}
 	iter.Release()
 
-	return nil, iter.Error()
+	err = iter.Error()
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, errors.New("Output not found")
 }
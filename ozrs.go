@@ -13,56 +13,59 @@ import (
  */
 
 type OZRS struct {
-	Preimage ECCPoint                 `json:"pimg"`
-	E        SHA256Sum                `json:"e"`
-	Rs       [TXN_NUM_INPUTS]*big.Int `json:"rs"`
-	Ss       [TXN_NUM_INPUTS]*big.Int `json:"ss"`
+	Preimage ECCPoint  `json:"pimg"`
+	E        SHA256Sum `json:"e"`
+	Rs       []Scalar  `json:"rs"`
+	Ss       []Scalar  `json:"ss"`
 }
 
 /*
  * Signs a txn given the public keys, input commitments, and other secret data.
+ * The ring size is the number of public keys.
  */
 func (txn *Txn) OZRSSign(pks, ics []ECCPoint,
 	sk, yi *big.Int,
 	idx int,
-	yOut *big.Int) {
+	yOut *big.Int,
+	version uint8) {
 
 	// Message is hash of txn body.
-	M := txn.BodyJson()
+	M := txn.BodyBytes()
 	hashM := Hash(M)
 
 	// Calculate signing key preimage
-	pimg := Preimage(pks[idx], sk)
+	pimg := Preimage(pks[idx], sk, version)
 
 	// Calculate commit differences
 	diffs := txn.commitDifferences(ics)
 
-	es := [TXN_NUM_INPUTS]SHA256Sum{}
-	rs := [TXN_NUM_INPUTS]*big.Int{}
-	ss := [TXN_NUM_INPUTS]*big.Int{}
+	n := len(pks)
+	es := make([]SHA256Sum, n)
+	rs := make([]Scalar, n)
+	ss := make([]Scalar, n)
 
 	// Compute target e[idx+1] = H( M | k1 G | k2 G | k2 H_P(X_i) )
-	next := (idx + 1) % TXN_NUM_INPUTS
+	next := (idx + 1) % n
 
 	// Start with k1 G, k2 G, and k2 H_P(X_i)
 	k1, k2 := RandomInt(), RandomInt()
-	k1Gx, k1Gy := CURVE.ScalarBaseMult(k1.Bytes())
-	k2Gx, k2Gy := CURVE.ScalarBaseMult(k2.Bytes())
-	k2HP := Preimage(pks[idx], k2)
+	k1G := CURVE.BaseMult(k1.Bytes())
+	k2G := CURVE.BaseMult(k2.Bytes())
+	k2HP := Preimage(pks[idx], k2, version)
 
 	// Hash with message
 	eidxData := hashM.Bytes()
-	eidxData = append(eidxData, ECCPoint{k1Gx, k1Gy}.Bytes()...)
-	eidxData = append(eidxData, ECCPoint{k2Gx, k2Gy}.Bytes()...)
+	eidxData = append(eidxData, k1G.Bytes()...)
+	eidxData = append(eidxData, k2G.Bytes()...)
 	eidxData = append(eidxData, k2HP.Bytes()...)
 	es[next] = Hash(eidxData)
 
 	// Compute forward in ring
-	for i := next; i != idx; i = (i + 1) % TXN_NUM_INPUTS {
+	for i := next; i != idx; i = (i + 1) % n {
 		// Choose arbitrarily
-		rs[i], ss[i] = RandomInt(), RandomInt()
-		next = (i + 1) % TXN_NUM_INPUTS
-		es[next] = computeE3(hashM, rs[i], ss[i], es[i], diffs[i], pks[i], pimg)
+		rs[i], ss[i] = RandomScalar(), RandomScalar()
+		next = (i + 1) % n
+		es[next] = computeE3(hashM, rs[i], ss[i], es[i], diffs[i], pks[i], pimg, version)
 	}
 
 	e1 := es[idx]
@@ -71,7 +74,7 @@ func (txn *Txn) OZRSSign(pks, ics []ECCPoint,
 	// z = input blinding factor - output blinding factor, the sk for diffs[idx]
 	z := &big.Int{}
 	z.Sub(yi, yOut)
-	z.Mod(z, CURVE.Params().N)
+	z.Mod(z, CURVE.Order())
 
 	// Complete ring
 	rs[idx] = timeTravel(z, k1, e1)
@@ -85,10 +88,11 @@ func (txn *Txn) OZRSSign(pks, ics []ECCPoint,
 }
 
 /*
- * Verifies OZRS Signture given the public keys and input commitments.
+ * Verifies OZRS Signture given the public keys, input commitments and the
+ * ring's hash to point version.
  */
-func (txn Txn) VerifyOZRS(pks, ics []ECCPoint) bool {
-	M := txn.BodyJson()
+func (txn Txn) VerifyOZRS(pks, ics []ECCPoint, version uint8) bool {
+	M := txn.BodyBytes()
 	hashM := Hash(M)
 
 	// Calculate commit differences
@@ -97,18 +101,29 @@ func (txn Txn) VerifyOZRS(pks, ics []ECCPoint) bool {
 	// Retrieve preimage
 	pimg := txn.Sig.Preimage
 
-	es := [TXN_NUM_INPUTS]SHA256Sum{}
+	// Ring must cover every input
+	n := len(pks)
+	if n == 0 || len(ics) != n || len(txn.Sig.Rs) != n || len(txn.Sig.Ss) != n {
+		return false
+	}
+
+	// Reducing a scalar mod N must not be able to change the txn hash
+	if !CanonicalScalars(txn.Sig.Rs) || !CanonicalScalars(txn.Sig.Ss) {
+		return false
+	}
+
+	es := make([]SHA256Sum, n)
 	es[0] = txn.Sig.E
 
 	// Forward compute in ring
-	for i := 0; i < TXN_NUM_INPUTS-1; i++ {
+	for i := 0; i < n-1; i++ {
 		r, s := txn.Sig.Rs[i], txn.Sig.Ss[i]
-		es[i+1] = computeE3(hashM, r, s, es[i], diffs[i], pks[i], pimg)
+		es[i+1] = computeE3(hashM, r, s, es[i], diffs[i], pks[i], pimg, version)
 	}
 
 	// Loop back to beginning
-	li := TXN_NUM_INPUTS - 1
-	e0 := computeE3(hashM, txn.Sig.Rs[li], txn.Sig.Ss[li], es[li], diffs[li], pks[li], pimg)
+	li := n - 1
+	e0 := computeE3(hashM, txn.Sig.Rs[li], txn.Sig.Ss[li], es[li], diffs[li], pks[li], pimg, version)
 
 	// Should be equal to Sig.E in txn
 	return bytes.Compare(txn.Sig.E[:], e0[:]) == 0
@@ -118,13 +133,14 @@ func (txn Txn) VerifyOZRS(pks, ics []ECCPoint) bool {
  * Computes the triple-wide Chameleon hash for OZRS.
  */
 func computeE3(hashM SHA256Sum,
-	r, s *big.Int,
+	r, s Scalar,
 	e1 SHA256Sum,
-	di, pki, I ECCPoint) SHA256Sum {
+	di, pki, I ECCPoint,
+	version uint8) SHA256Sum {
 
 	e2 := Hash(e1[:])
 
-	imgi := Preimage(pki, nil)
+	imgi := Preimage(pki, nil, version)
 
 	r1 := computeR(r, e1, di)
 	r2 := computeR(s, e2, pki)
@@ -142,7 +158,7 @@ func computeE3(hashM SHA256Sum,
 /*
  * Computes used to compute Pedersen differences with arbitrary bases.
  */
-func computeR2(s *big.Int, e SHA256Sum, base, pk ECCPoint) ECCPoint {
+func computeR2(s Scalar, e SHA256Sum, base, pk ECCPoint) ECCPoint {
 	return PedersenDiffPK2(s.Bytes(), e[:], base, pk)
 }
 
@@ -150,13 +166,10 @@ func computeR2(s *big.Int, e SHA256Sum, base, pk ECCPoint) ECCPoint {
  * Computes blind * BASE - amt * PK
  */
 func PedersenDiffPK2(blind, amt []byte, base, pk ECCPoint) ECCPoint {
-	xGx, xGy := CURVE.Params().ScalarMult(base.X, base.Y, blind)
-	ePx, ePy := CURVE.Params().ScalarMult(pk.X, pk.Y, amt)
-	ePy.Neg(ePy)
-
-	x, y := CURVE.Params().Add(xGx, xGy, ePx, ePy)
+	xB := CURVE.Mult(base, blind)
+	eP := CURVE.Mult(pk, amt).Neg()
 
-	return ECCPoint{x, y}
+	return CURVE.Add(xB, eP)
 }
 
 /*
@@ -165,9 +178,9 @@ func PedersenDiffPK2(blind, amt []byte, base, pk ECCPoint) ECCPoint {
  */
 func (txn Txn) commitDifferences(ics []ECCPoint) []ECCPoint {
 	// Sum output commitments and take negative
-	ocx, ocy := &big.Int{}, &big.Int{}
+	oc := ECCPoint{&big.Int{}, &big.Int{}}
 	for _, otpt := range txn.Body.Outputs {
-		ocx, ocy = CURVE.Params().Add(ocx, ocy, otpt.Commit.X, otpt.Commit.Y)
+		oc = CURVE.Add(oc, otpt.Commit.ECCPoint)
 	}
 
 	// Add fee*H
@@ -175,17 +188,15 @@ func (txn Txn) commitDifferences(ics []ECCPoint) []ECCPoint {
 	feeBytes := UIntBytes(txn.Body.Fee)
 	feec := PedersenSum(zero.Bytes(), feeBytes)
 
-	ocx, ocy = CURVE.Params().Add(ocx, ocy, feec.X, feec.Y)
+	oc = CURVE.Add(oc, feec)
 
 	// Take negative
-	ocy.Neg(ocy)
-	oc := ECCPoint{ocx, ocy}
+	oc = oc.Neg()
 
 	// Subtract total output commitment from each input commitment
 	diffs := []ECCPoint{}
 	for _, c := range ics {
-		dix, diy := CURVE.Params().Add(c.X, c.Y, oc.X, oc.Y)
-		diffs = append(diffs, ECCPoint{dix, diy})
+		diffs = append(diffs, CURVE.Add(c, oc))
 	}
 
 	return diffs
@@ -24,13 +24,66 @@ func TestOZRSSign(t *testing.T) {
 		},
 	}
 
-	txn.OZRSSign(pks, ics, sec, yi, 0, bf)
+	txn.OZRSSign(pks, ics, sec, yi, 0, bf, HASH_TO_PT_TRY_INCREMENT)
 
-	if !txn.VerifyOZRS(pks, ics) {
+	if !txn.VerifyOZRS(pks, ics, HASH_TO_PT_TRY_INCREMENT) {
 		t.Error("OZRS Failed to verify")
 	}
 }
 
+func TestOZRSHashToPtVersions(t *testing.T) {
+	amts := []uint64{1, 4999999998}
+	rcpts := []WalletPublicKey{
+		NewPrivateKey().PublicKey(),
+		NewPrivateKey().PublicKey(),
+	}
+
+	pks, sec := pksAndSecret()
+	ics, yi := commitmentsAndBF(5000000000)
+	outputs, bf := BuildOutputs(amts, rcpts)
+
+	// Signatures made before the switch must keep verifying, but only as legacy
+	for _, version := range []uint8{HASH_TO_PT_LEGACY, HASH_TO_PT_TRY_INCREMENT} {
+		txn := Txn{
+			Body: TxnBody{
+				Outputs: outputs,
+				Fee:     1,
+			},
+		}
+		txn.OZRSSign(pks, ics, sec, yi, 0, bf, version)
+
+		if !txn.VerifyOZRS(pks, ics, version) {
+			t.Error("OZRS failed to verify with hash to point version", version)
+		}
+
+		if txn.VerifyOZRS(pks, ics, 1-version) {
+			t.Error("OZRS verified with the wrong hash to point version", version)
+		}
+	}
+}
+
+func TestRingHashToPtVersion(t *testing.T) {
+	params := RegTestParams
+	params.HashToPtHeight = 10
+
+	tests := []struct {
+		heights []uint64
+		version uint8
+		valid   bool
+	}{
+		{[]uint64{0, 9}, HASH_TO_PT_LEGACY, true},
+		{[]uint64{10, 11}, HASH_TO_PT_TRY_INCREMENT, true},
+		{[]uint64{9, 10}, 0, false},
+	}
+
+	for _, test := range tests {
+		version, err := params.RingHashToPtVersion(test.heights)
+		if (err == nil) != test.valid || version != test.version {
+			t.Error("Unexpected hash to point version for heights", test.heights)
+		}
+	}
+}
+
 func BenchmarkOZRSSign(b *testing.B) {
 	prevAmt := uint64(5000000000)
 	amts := []uint64{1, 4999999998}
@@ -52,7 +105,7 @@ func BenchmarkOZRSSign(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		txn.OZRSSign(pks, ics, sec, yi, 0, bf)
+		txn.OZRSSign(pks, ics, sec, yi, 0, bf, HASH_TO_PT_TRY_INCREMENT)
 	}
 }
 
@@ -75,23 +128,22 @@ func BenchmarkOZRSVerify(b *testing.B) {
 		},
 	}
 
-	txn.OZRSSign(pks, ics, sec, yi, 0, bf)
+	txn.OZRSSign(pks, ics, sec, yi, 0, bf, HASH_TO_PT_TRY_INCREMENT)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		txn.VerifyOZRS(pks, ics)
+		txn.VerifyOZRS(pks, ics, HASH_TO_PT_TRY_INCREMENT)
 	}
 }
 
 func pksAndSecret() ([]ECCPoint, *big.Int) {
 	var sec *big.Int
 	pks := []ECCPoint{}
-	for i := 0; i < TXN_NUM_INPUTS; i++ {
+	for i := 0; i < MainNetParams.TxnNumInputs; i++ {
 		s := RandomInt()
 		if i == 0 {
 			sec = s
 		}
-		pkx, pky := CURVE.Params().ScalarBaseMult(s.Bytes())
-		pks = append(pks, ECCPoint{pkx, pky})
+		pks = append(pks, CURVE.BaseMult(s.Bytes()))
 	}
 
 	return pks, sec
@@ -100,7 +152,7 @@ func pksAndSecret() ([]ECCPoint, *big.Int) {
 func commitmentsAndBF(amt uint64) ([]ECCPoint, *big.Int) {
 	var yi *big.Int
 	ics := []ECCPoint{}
-	for i := 0; i < TXN_NUM_INPUTS; i++ {
+	for i := 0; i < MainNetParams.TxnNumInputs; i++ {
 		//b := RandomInt()
 		b := &big.Int{}
 		commit := RangeCommit(amt, b)
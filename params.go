@@ -0,0 +1,335 @@
+package ozcoin
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+)
+
+/*
+ * ChainParams
+ *
+ * Consensus rules and defaults that differ between networks.  Every client is
+ * bound to a single set of params, and peers on a different `Net` are refused.
+ */
+type ChainParams struct {
+	Name         string
+	Net          uint32
+	DataDir      string
+	MinerPort    string
+	SPVPort      string
+	WalletPort   string
+	TemplatePort string
+	StratumPort  string
+
+	// Proof of work
+	PowLimitBits     uint32
+	RetargetInterval uint64
+	TargetTimespan   int64
+	NoRetargeting    bool
+	MaxFutureDrift   time.Duration
+
+	// Block reward and txns
+	HalvingInterval  uint64
+	CoinbaseMaturity uint64 // blocks before a coinbase output may be an input
+	TxnNumInputs     int
+	HashToPtHeight   uint64 // outputs created from here use the secure hash to point
+	Curve            Curve  // group for keys, commitments and signatures
+	PedersenDomain   string // hashed to the curve for the commitment generator H
+
+	// Canonical genesis block, rebuilt by `GenesisBlock`
+	GenesisTime      time.Time
+	GenesisPublicKey ECCPoint
+	GenesisDestKey   ECCPoint
+	GenesisNonce     uint64
+	GenesisHash      SHA256Sum
+}
+
+var MainNetParams = ChainParams{
+	Name:         "mainnet",
+	Net:          0x4f5a436d, // "OZCm"
+	DataDir:      "db",
+	MinerPort:    "6000",
+	SPVPort:      "6001",
+	WalletPort:   "6002",
+	TemplatePort: "6003",
+	StratumPort:  "6004",
+
+	PowLimitBits:     0x1f00ffff, // ~2^240, 16 leading zero bits
+	RetargetInterval: 2016,
+	TargetTimespan:   14 * 24 * 60 * 60, // 2 weeks in seconds
+	MaxFutureDrift:   MAX_FUTURE_DRIFT,
+
+	HalvingInterval:  21000,
+	CoinbaseMaturity: 100,
+	TxnNumInputs:     8,
+	HashToPtHeight:   0,
+	Curve:            P256Curve,
+	PedersenDomain:   PEDERSEN_H_DOMAIN,
+
+	GenesisTime: time.Unix(1454198400, 0).UTC(),
+	GenesisPublicKey: ECCPoint{
+		hexInt("1254a6ba1c1bebc626b1822981c1d1a64143b52971d516fb39cc80d7d43b8982"),
+		hexInt("e21e6f4b082394f189ac5b8a86ff896a8df2d8e85b715bf766a943dbcb4cb29c"),
+	},
+	GenesisDestKey: ECCPoint{
+		hexInt("3de21971afb099c1146b9f32c90a68fbb42d9d020427b1959a35c1c50081eb78"),
+		hexInt("f44507055770bd37c9dd3b19f32440329384bd3ffd26f5ba527983007466f5bf"),
+	},
+	GenesisNonce: 144169,
+	GenesisHash:  hexHash("00001eace2aeb6fef6689063f29db7e9f253ea703fff641bbd929a496b08792b"),
+}
+
+var TestNetParams = ChainParams{
+	Name:         "testnet",
+	Net:          0x4f5a4374, // "OZCt"
+	DataDir:      "db/testnet",
+	MinerPort:    "16000",
+	SPVPort:      "16001",
+	WalletPort:   "16002",
+	TemplatePort: "16003",
+	StratumPort:  "16004",
+
+	PowLimitBits:     0x1f00ffff,
+	RetargetInterval: 2016,
+	TargetTimespan:   14 * 24 * 60 * 60,
+	MaxFutureDrift:   MAX_FUTURE_DRIFT,
+
+	HalvingInterval:  21000,
+	CoinbaseMaturity: 100,
+	TxnNumInputs:     8,
+	HashToPtHeight:   0,
+	Curve:            P256Curve,
+	PedersenDomain:   PEDERSEN_H_DOMAIN,
+
+	GenesisTime: time.Unix(1454198400, 0).UTC(),
+	GenesisPublicKey: ECCPoint{
+		hexInt("3a4304f94239874783b009a61dad883edc5a68c7a1acb57b1d65f96cbf4a7309"),
+		hexInt("570af429210d8ca8ffb6c82115890cb0b4b9dd634c2f35e8fbf115fd9191d11"),
+	},
+	GenesisDestKey: ECCPoint{
+		hexInt("4a4f9550cafc6a33edb89f4a697712d75a97313caaba98cac450dcc935189f6b"),
+		hexInt("e6f0e9210883edd4227774b160e6fad0c91550b812f559659ca9bfea830e68c1"),
+	},
+	GenesisNonce: 15207,
+	GenesisHash:  hexHash("0000566f3a7b1c73539c60e9cfff2b02515aea67b19cacaa097ab0be1b5fde8f"),
+}
+
+/*
+ * Trivial difficulty and a short halving interval, for local testing.
+ */
+var RegTestParams = ChainParams{
+	Name:         "regtest",
+	Net:          0x4f5a4372, // "OZCr"
+	DataDir:      "db/regtest",
+	MinerPort:    "26000",
+	SPVPort:      "26001",
+	WalletPort:   "26002",
+	TemplatePort: "26003",
+	StratumPort:  "26004",
+
+	PowLimitBits:     0x207fffff, // ~2^255, half of all hashes
+	RetargetInterval: 2016,
+	TargetTimespan:   14 * 24 * 60 * 60,
+	NoRetargeting:    true,
+	MaxFutureDrift:   MAX_FUTURE_DRIFT,
+
+	HalvingInterval:  150,
+	CoinbaseMaturity: 10,
+	TxnNumInputs:     8,
+	HashToPtHeight:   0,
+	Curve:            P256Curve,
+	PedersenDomain:   PEDERSEN_H_DOMAIN,
+
+	GenesisTime: time.Unix(1454198400, 0).UTC(),
+	GenesisPublicKey: ECCPoint{
+		hexInt("566171ea96c932df32d258f27279377aec7ed31e300cc403b208e133b8ca775d"),
+		hexInt("7c73b0a9ac8bf90cb255956f37ef1ea8b813649d0de411991d7ac640ff7a290f"),
+	},
+	GenesisDestKey: ECCPoint{
+		hexInt("a40d9c6a7fbd8648b1984fd2e450f091055ef0e67c6637a8c538578e1aa7ed72"),
+		hexInt("2d34a998f8e664f0562208793b55cc7f34ef0e32045042ba8c1d0d62260578fe"),
+	},
+	GenesisNonce: 0,
+	GenesisHash:  hexHash("1bcc670a7916e3c546275489360723f6de85ee013405169c39c0f3748fad3306"),
+}
+
+/*
+ * RegTestSecp256k1Params
+ *
+ * Regtest on secp256k1.  Genesis keys are points on the network's curve, so
+ * each curve needs its own genesis block.
+ */
+var RegTestSecp256k1Params = ChainParams{
+	Name:         "regtest-secp256k1",
+	Net:          0x4f5a436b, // "OZCk"
+	DataDir:      "db/regtest-secp256k1",
+	MinerPort:    "36000",
+	SPVPort:      "36001",
+	WalletPort:   "36002",
+	TemplatePort: "36003",
+	StratumPort:  "36004",
+
+	PowLimitBits:     0x207fffff,
+	RetargetInterval: 2016,
+	TargetTimespan:   14 * 24 * 60 * 60,
+	NoRetargeting:    true,
+	MaxFutureDrift:   MAX_FUTURE_DRIFT,
+
+	HalvingInterval:  150,
+	CoinbaseMaturity: 10,
+	TxnNumInputs:     8,
+	HashToPtHeight:   0,
+	Curve:            Secp256k1Curve,
+	PedersenDomain:   PEDERSEN_H_DOMAIN,
+
+	GenesisTime: time.Unix(1454198400, 0).UTC(),
+	GenesisPublicKey: ECCPoint{
+		hexInt("cb49f1173f440922f71d06de78151e85b1ecff33db85de0b00fb5aeb20e786ad"),
+		hexInt("e48ec117c424bccd636133fd42c7b7fa318d0d8e3c8c4c5bfaf9571b054e8bd5"),
+	},
+	GenesisDestKey: ECCPoint{
+		hexInt("e0324d276cf81e6399aeeb48d0dcc6a88fb241cffd113678b88007ed3fe428ff"),
+		hexInt("c9d944bb89216a723fcb0fa9a0f5777e98fb4e331e5a8c81f472d199f34cce96"),
+	},
+	GenesisNonce: 0,
+	GenesisHash:  hexHash("451d165edde41489dcf7a0d8d06a9621bd145f047bb8d96b38c60e832ae76e72"),
+}
+
+/*
+ * Parses a hex literal, panicking on malformed input since params are fixed at
+ * compile time.
+ */
+func hexInt(s string) *big.Int {
+	i, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("Invalid hex integer in params: " + s)
+	}
+
+	return i
+}
+
+func hexHash(s string) SHA256Sum {
+	h := SHA256Sum{}
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(h) {
+		panic("Invalid hex hash in params: " + s)
+	}
+	copy(h[:], b)
+
+	return h
+}
+
+/*
+ * Looks up predefined params by network name.
+ */
+func ParamsForNet(name string) (*ChainParams, error) {
+	for _, params := range []*ChainParams{&MainNetParams, &TestNetParams, &RegTestParams, &RegTestSecp256k1Params} {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+
+	return nil, errors.New("Unknown network: " + name)
+}
+
+/*
+ * The largest target allowed on the network.
+ */
+func (p *ChainParams) PowLimit() *big.Int {
+	return CompactToBig(p.PowLimitBits)
+}
+
+/*
+ * The block reward at `seqNum`, halving every `HalvingInterval` blocks.
+ */
+func (p *ChainParams) CoinbaseValue(seqNum uint64) uint64 {
+	halvings := seqNum / p.HalvingInterval
+	if halvings >= 64 {
+		return 0
+	}
+
+	return (50 * 100000000) >> halvings
+}
+
+/*
+ * The hash to point version for a ring given the heights its inputs were
+ * created at.  Outputs created before `HashToPtHeight` are spent with legacy
+ * preimages so each output only ever has one preimage, which means a ring may
+ * not mix outputs from both sides of the switch.
+ */
+func (p *ChainParams) RingHashToPtVersion(heights []uint64) (uint8, error) {
+	legacy := 0
+	for _, height := range heights {
+		if height < p.HashToPtHeight {
+			legacy++
+		}
+	}
+
+	switch legacy {
+	case 0:
+		return HASH_TO_PT_TRY_INCREMENT, nil
+	case len(heights):
+		return HASH_TO_PT_LEGACY, nil
+	default:
+		return 0, errors.New("Ring mixes hash to point versions")
+	}
+}
+
+var (
+	paramsMtx   sync.Mutex
+	paramsBound bool // a client is running on `CURVE` and `H`
+)
+
+/*
+ * Makes the network's `Curve` the process wide `CURVE` and derives the
+ * commitment generator `H` on it from the network's `PedersenDomain`.  Once a
+ * client has bound the process with `bindParams`, params on another curve or
+ * domain are rejected, since that client would silently switch curves.
+ */
+func UseParams(params *ChainParams) error {
+	paramsMtx.Lock()
+	defer paramsMtx.Unlock()
+
+	return useParams(params)
+}
+
+/*
+ * Uses the params and keeps any other curve or domain out of the process.
+ */
+func bindParams(params *ChainParams) error {
+	paramsMtx.Lock()
+	defer paramsMtx.Unlock()
+
+	err := useParams(params)
+	if err != nil {
+		return err
+	}
+
+	paramsBound = true
+
+	return nil
+}
+
+func useParams(params *ChainParams) error {
+	curve := params.Curve
+	if !curve.IsOnCurve(params.GenesisPublicKey) || !curve.IsOnCurve(params.GenesisDestKey) {
+		return errors.New("Genesis keys are not on " + curve.Name())
+	}
+
+	h := curve.HashToPt([]byte(params.PedersenDomain))
+	if CURVE == curve && h.X.Cmp(H.X) == 0 && h.Y.Cmp(H.Y) == 0 {
+		return nil
+	}
+
+	if paramsBound {
+		return errors.New("Clients in one process must share a curve and Pedersen domain")
+	}
+
+	CURVE = curve
+	H = h
+
+	return nil
+}
@@ -10,7 +10,7 @@ const RANGE_PROOF_LENGTH = 34
 
 type RangeProof struct {
 	E   SHA256Sum                       `json:"e"`
-	Ss  [RANGE_PROOF_LENGTH][2]*big.Int `json:"ss"`
+	Ss  [RANGE_PROOF_LENGTH][2]Scalar   `json:"ss"`
 	PKs [RANGE_PROOF_LENGTH][2]ECCPoint `json:"pub_keys"`
 }
 
@@ -25,16 +25,22 @@ func (s RangeProof) HashPKs() SHA256Sum {
 }
 
 func RangeSign(amt uint64, targetBlind *big.Int) RangeProof {
-	sig := RangeProof{}
-
 	blinds := ComputeBlinds(targetBlind)
+
+	pks := [RANGE_PROOF_LENGTH][2]ECCPoint{}
 	for i, blind := range blinds {
-		sig.PKs[i] = PKsForAmt(amt, uint64(i), blind)
+		pks[i] = PKsForAmt(amt, uint64(i), blind)
 	}
 
-	ocx, ocy := &big.Int{}, &big.Int{}
-	for _, pks := range sig.PKs {
-		ocx, ocy = CURVE.Params().Add(ocx, ocy, pks[0].X, pks[0].Y)
+	return signRange(pks, amt, blinds)
+}
+
+/*
+ * Signs each bit's pair of keys with the blind for the key selected by `amt`.
+ */
+func signRange(pks [RANGE_PROOF_LENGTH][2]ECCPoint, amt uint64, blinds []*big.Int) RangeProof {
+	sig := RangeProof{
+		PKs: pks,
 	}
 
 	hashM := sig.HashPKs()
@@ -47,12 +53,12 @@ func RangeSign(amt uint64, targetBlind *big.Int) RangeProof {
 		signNonZero := value&amt > 0
 
 		ks[i] = RandomInt()
-		kGx, kGy := CURVE.Params().ScalarBaseMult(ks[i].Bytes())
+		kG := CURVE.BaseMult(ks[i].Bytes())
 		if signNonZero {
-			rs[i] = ECCPoint{kGx, kGy}
+			rs[i] = kG
 		} else {
-			e1 := HashPt(hashM.Bytes(), ECCPoint{kGx, kGy})
-			sig.Ss[i][1] = RandomInt()
+			e1 := HashPt(hashM.Bytes(), kG)
+			sig.Ss[i][1] = RandomScalar()
 			rs[i] = computeR(sig.Ss[i][1], e1, sig.PKs[i][1])
 		}
 	}
@@ -71,7 +77,7 @@ func RangeSign(amt uint64, targetBlind *big.Int) RangeProof {
 		signNonZero := value&amt > 0
 
 		if signNonZero {
-			sig.Ss[i][0] = RandomInt()
+			sig.Ss[i][0] = RandomScalar()
 			e1 := computeE(hashM, sig.Ss[i][0], sig.E, sig.PKs[i][0])
 			sig.Ss[i][1] = timeTravel(blinds[i], ks[i], e1)
 		} else {
@@ -82,7 +88,34 @@ func RangeSign(amt uint64, targetBlind *big.Int) RangeProof {
 	return sig
 }
 
+/*
+ * The commitment proven to be in range, the sum of the bit commitments.
+ */
+func (rp RangeProof) CommitSum() ECCPoint {
+	commit := ECCPoint{&big.Int{}, &big.Int{}}
+	for i := 0; i < RANGE_PROOF_LENGTH; i++ {
+		commit = CURVE.Add(commit, rp.PKs[i][0])
+	}
+
+	return commit
+}
+
 func (rp RangeProof) Verify() bool {
+	for i := 0; i < RANGE_PROOF_LENGTH; i++ {
+		if !CanonicalScalars(rp.Ss[i][:]) {
+			return false
+		}
+	}
+
+	// Each bit's keys must differ by exactly that bit's value, otherwise a bit
+	// could commit to any amount
+	for i := 0; i < RANGE_PROOF_LENGTH; i++ {
+		value := CURVE.Mult(H, UIntBytes(uint64(1)<<uint(i)))
+		if rp.PKs[i][1].Empty() || !rp.PKs[i][0].Equal(CURVE.Add(rp.PKs[i][1], value)) {
+			return false
+		}
+	}
+
 	hashM := rp.HashPKs()
 	e0data := []byte{}
 	e0data = append(e0data, hashM.Bytes()...)
@@ -108,13 +141,13 @@ func ComputeBlinds(targetBlind *big.Int) []*big.Int {
 
 		if i == RANGE_PROOF_LENGTH-1 {
 			r.Sub(targetBlind, sumBlind)
-			r.Mod(r, CURVE.Params().N)
+			r.Mod(r, CURVE.Order())
 
 			sumBlind.Add(sumBlind, r)
-			sumBlind.Mod(sumBlind, CURVE.Params().N)
+			sumBlind.Mod(sumBlind, CURVE.Order())
 		} else {
 			hash := Hash(r.Bytes())
-			r.SetBytes(hash.Bytes())
+			r.Mod(hash.Int(), CURVE.Order())
 			sumBlind.Add(sumBlind, r)
 		}
 
@@ -132,33 +165,28 @@ func PKsForAmt(amt uint64, bit uint64, blind *big.Int) [2]ECCPoint {
 	commitBytes := UIntBytes(commit)
 
 	diff := PedersenSum(big.NewInt(0).Bytes(), valueBytes)
-	diff.Y.Neg(diff.Y)
+	diff = diff.Neg()
 
 	c0 := PedersenSum(blind.Bytes(), commitBytes)
-	c1x, c1y := CURVE.Params().Add(c0.X, c0.Y, diff.X, diff.Y)
-
 	return [2]ECCPoint{
 		c0,
-		ECCPoint{c1x, c1y},
+		CURVE.Add(c0, diff),
 	}
 }
 
-func computeE(hashM SHA256Sum, s *big.Int, e SHA256Sum, pk ECCPoint) SHA256Sum {
+func computeE(hashM SHA256Sum, s Scalar, e SHA256Sum, pk ECCPoint) SHA256Sum {
 	r := computeR(s, e, pk)
 	return HashPt(hashM.Bytes(), r)
 }
 
-func computeR(s *big.Int, e SHA256Sum, pk ECCPoint) ECCPoint {
+func computeR(s Scalar, e SHA256Sum, pk ECCPoint) ECCPoint {
 	return PedersenDiffPK(s.Bytes(), e.Bytes(), pk)
 }
 
-func timeTravel(blind, k *big.Int, e SHA256Sum) *big.Int {
-	eInt := &big.Int{}
-	eInt.SetBytes(e.Bytes())
-
+func timeTravel(blind, k *big.Int, e SHA256Sum) Scalar {
 	s := &big.Int{}
-	s.Mul(eInt, blind)
+	s.Mul(e.Int(), blind)
 	s.Add(s, k)
 
-	return s
+	return NewScalar(s)
 }
@@ -0,0 +1,63 @@
+package ozcoin
+
+import (
+	"math/big"
+)
+
+/*
+ * Scalar
+ *
+ * An integer mod the curve order N, stored as 32 big-endian bytes.  Only values
+ * below N are canonical.  Signatures carrying a non-canonical scalar are
+ * rejected, otherwise anyone could add N to a scalar and change a txn's hash
+ * without invalidating its signature.
+ */
+
+const SCALAR_LENGTH = 32
+
+type Scalar [SCALAR_LENGTH]byte
+
+/*
+ * Reduces `i` mod N.
+ */
+func NewScalar(i *big.Int) Scalar {
+	r := &big.Int{}
+	r.Mod(i, CURVE.Order())
+
+	s := Scalar{}
+	r.FillBytes(s[:])
+
+	return s
+}
+
+func RandomScalar() Scalar {
+	return NewScalar(RandomInt())
+}
+
+func (s Scalar) Bytes() []byte {
+	return s[:]
+}
+
+func (s Scalar) Int() *big.Int {
+	i := &big.Int{}
+	i.SetBytes(s[:])
+
+	return i
+}
+
+func (s Scalar) Canonical() bool {
+	return s.Int().Cmp(CURVE.Order()) < 0
+}
+
+/*
+ * True if every scalar is canonical.
+ */
+func CanonicalScalars(ss []Scalar) bool {
+	for _, s := range ss {
+		if !s.Canonical() {
+			return false
+		}
+	}
+
+	return true
+}
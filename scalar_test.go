@@ -0,0 +1,70 @@
+package ozcoin
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestNewScalarReduces(t *testing.T) {
+	n := CURVE.Order()
+
+	i := &big.Int{}
+	i.Add(n, big.NewInt(5))
+
+	s := NewScalar(i)
+	if s.Int().Cmp(big.NewInt(5)) != 0 || !s.Canonical() {
+		t.Error("Scalar not reduced mod N")
+	}
+
+	high := Scalar{}
+	n.FillBytes(high[:])
+	if high.Canonical() {
+		t.Error("N accepted as a canonical scalar")
+	}
+}
+
+func TestVerifyRejectsNonCanonicalScalars(t *testing.T) {
+	high := Scalar{}
+	CURVE.Order().FillBytes(high[:])
+
+	amts := []uint64{1, 4999999998}
+	rcpts := []WalletPublicKey{
+		NewPrivateKey().PublicKey(),
+		NewPrivateKey().PublicKey(),
+	}
+
+	pks, sec := pksAndSecret()
+	ics, yi := commitmentsAndBF(5000000000)
+	outputs, bf := BuildOutputs(amts, rcpts)
+
+	txn := Txn{
+		Body: TxnBody{
+			Inputs:  make([]SHA256Sum, len(pks)),
+			Outputs: outputs,
+			Fee:     1,
+		},
+	}
+	txn.OZRSSign(pks, ics, sec, yi, 0, bf, HASH_TO_PT_TRY_INCREMENT)
+
+	txn.Sig.Ss[0] = high
+	if txn.VerifyOZRS(pks, ics, HASH_TO_PT_TRY_INCREMENT) {
+		t.Error("OZRS accepted a non-canonical scalar")
+	}
+
+	rp := txn.Body.Outputs[0].Commit.RangeProof
+	if !rp.Verify() {
+		t.Fatal("Range proof failed to verify")
+	}
+
+	rp.Ss[3][1] = high
+	if rp.Verify() {
+		t.Error("Range proof accepted a non-canonical scalar")
+	}
+
+	// Non-canonical scalars can't be decoded either
+	encoded := txn.Bytes()
+	decoded := Txn{}
+	if decoded.UnmarshalBinary(encoded) == nil {
+		t.Error("Decoded a non-canonical scalar")
+	}
+}
@@ -0,0 +1,223 @@
+package ozcoin
+
+import (
+	"math/big"
+)
+
+/*
+ * secp256k1
+ *
+ * A portable math/big backend for y^2 = x^3 + 7.  Points are added in
+ * Jacobian coordinates, with a single inversion when converting back to
+ * affine.  Unlike P256 its field arithmetic is not constant time.
+ */
+type secp256k1Curve struct {
+	weierstrass
+}
+
+var Secp256k1Curve Curve = newSecp256k1Curve()
+
+func newSecp256k1Curve() *secp256k1Curve {
+	return &secp256k1Curve{
+		weierstrass: weierstrass{
+			name:      "secp256k1",
+			p:         hexInt("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f"),
+			n:         hexInt("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141"),
+			a:         &big.Int{},
+			b:         big.NewInt(7),
+			gx:        hexInt("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"),
+			gy:        hexInt("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"),
+			h2pDomain: "ozcoin/hash-to-point/secp256k1/try-and-increment",
+		},
+	}
+}
+
+/*
+ * Jacobian point (X/Z^2, Y/Z^3), the point at infinity has Z = 0.
+ */
+type jacobian struct {
+	x, y, z *big.Int
+}
+
+func (c *secp256k1Curve) toJacobian(p ECCPoint) jacobian {
+	if p.Empty() || p.IsIdentity() {
+		return jacobian{&big.Int{}, big.NewInt(1), &big.Int{}}
+	}
+
+	return jacobian{
+		new(big.Int).Set(p.X),
+		new(big.Int).Set(p.Y),
+		big.NewInt(1),
+	}
+}
+
+func (c *secp256k1Curve) toAffine(j jacobian) ECCPoint {
+	if j.z.Sign() == 0 {
+		return ECCPoint{&big.Int{}, &big.Int{}}
+	}
+
+	zInv := &big.Int{}
+	zInv.ModInverse(j.z, c.p)
+	zInv2 := &big.Int{}
+	zInv2.Mul(zInv, zInv)
+
+	x := &big.Int{}
+	x.Mul(j.x, zInv2)
+	x.Mod(x, c.p)
+
+	y := &big.Int{}
+	y.Mul(j.y, zInv2)
+	y.Mul(y, zInv)
+	y.Mod(y, c.p)
+
+	return ECCPoint{x, y}
+}
+
+/*
+ * dbl-2009-l, for curves with a = 0.
+ */
+func (c *secp256k1Curve) double(j jacobian) jacobian {
+	if j.z.Sign() == 0 || j.y.Sign() == 0 {
+		return jacobian{&big.Int{}, big.NewInt(1), &big.Int{}}
+	}
+
+	a := new(big.Int).Mul(j.x, j.x)
+	a.Mod(a, c.p)
+	b := new(big.Int).Mul(j.y, j.y)
+	b.Mod(b, c.p)
+	cc := new(big.Int).Mul(b, b)
+	cc.Mod(cc, c.p)
+
+	// D = 2*((X+B)^2 - A - C)
+	d := new(big.Int).Add(j.x, b)
+	d.Mul(d, d)
+	d.Sub(d, a)
+	d.Sub(d, cc)
+	d.Lsh(d, 1)
+	d.Mod(d, c.p)
+
+	e := new(big.Int).Mul(a, big.NewInt(3))
+	f := new(big.Int).Mul(e, e)
+
+	x := new(big.Int).Sub(f, new(big.Int).Lsh(d, 1))
+	x.Mod(x, c.p)
+
+	y := new(big.Int).Sub(d, x)
+	y.Mul(y, e)
+	y.Sub(y, new(big.Int).Lsh(cc, 3))
+	y.Mod(y, c.p)
+
+	z := new(big.Int).Mul(j.y, j.z)
+	z.Lsh(z, 1)
+	z.Mod(z, c.p)
+
+	return jacobian{x, y, z}
+}
+
+/*
+ * add-2007-bl, falling back to doubling when both points are equal.
+ */
+func (c *secp256k1Curve) add(j1, j2 jacobian) jacobian {
+	if j1.z.Sign() == 0 {
+		return j2
+	}
+	if j2.z.Sign() == 0 {
+		return j1
+	}
+
+	z1z1 := new(big.Int).Mul(j1.z, j1.z)
+	z1z1.Mod(z1z1, c.p)
+	z2z2 := new(big.Int).Mul(j2.z, j2.z)
+	z2z2.Mod(z2z2, c.p)
+
+	u1 := new(big.Int).Mul(j1.x, z2z2)
+	u1.Mod(u1, c.p)
+	u2 := new(big.Int).Mul(j2.x, z1z1)
+	u2.Mod(u2, c.p)
+
+	s1 := new(big.Int).Mul(j1.y, j2.z)
+	s1.Mul(s1, z2z2)
+	s1.Mod(s1, c.p)
+	s2 := new(big.Int).Mul(j2.y, j1.z)
+	s2.Mul(s2, z1z1)
+	s2.Mod(s2, c.p)
+
+	h := new(big.Int).Sub(u2, u1)
+	h.Mod(h, c.p)
+	r := new(big.Int).Sub(s2, s1)
+	r.Lsh(r, 1)
+	r.Mod(r, c.p)
+
+	if h.Sign() == 0 {
+		if r.Sign() == 0 {
+			return c.double(j1)
+		}
+		return jacobian{&big.Int{}, big.NewInt(1), &big.Int{}}
+	}
+
+	i := new(big.Int).Lsh(h, 1)
+	i.Mul(i, i)
+	i.Mod(i, c.p)
+	jj := new(big.Int).Mul(h, i)
+	jj.Mod(jj, c.p)
+	v := new(big.Int).Mul(u1, i)
+	v.Mod(v, c.p)
+
+	x := new(big.Int).Mul(r, r)
+	x.Sub(x, jj)
+	x.Sub(x, new(big.Int).Lsh(v, 1))
+	x.Mod(x, c.p)
+
+	y := new(big.Int).Sub(v, x)
+	y.Mul(y, r)
+	y.Sub(y, new(big.Int).Lsh(new(big.Int).Mul(s1, jj), 1))
+	y.Mod(y, c.p)
+
+	z := new(big.Int).Add(j1.z, j2.z)
+	z.Mul(z, z)
+	z.Sub(z, z1z1)
+	z.Sub(z, z2z2)
+	z.Mul(z, h)
+	z.Mod(z, c.p)
+
+	return jacobian{x, y, z}
+}
+
+func (c *secp256k1Curve) BaseMult(k []byte) ECCPoint {
+	return c.Mult(ECCPoint{c.gx, c.gy}, k)
+}
+
+/*
+ * Montgomery ladder over k mod N.  N or 2N is added first so every scalar is
+ * exactly one bit longer than N, and each bit costs one addition and one
+ * doubling, selected by indexing rather than branching.  The sequence of
+ * operations is the same for every scalar, though math/big itself is not
+ * constant time.
+ */
+func (c *secp256k1Curve) Mult(p ECCPoint, k []byte) ECCPoint {
+	if p.Empty() || p.IsIdentity() {
+		return ECCPoint{&big.Int{}, &big.Int{}}
+	}
+
+	scalar := new(big.Int).SetBytes(k)
+	scalar.Mod(scalar, c.n)
+	scalar.Add(scalar, c.n)
+	if scalar.BitLen() == c.n.BitLen() {
+		scalar.Add(scalar, c.n)
+	}
+
+	// r[1] - r[0] = p throughout, so an addition is never a doubling
+	r0 := c.toJacobian(p)
+	r := [2]jacobian{r0, c.double(r0)}
+	for i := c.n.BitLen() - 1; i >= 0; i-- {
+		bit := scalar.Bit(i)
+		r[1-bit] = c.add(r[0], r[1])
+		r[bit] = c.double(r[bit])
+	}
+
+	return c.toAffine(r[0])
+}
+
+func (c *secp256k1Curve) Add(a, b ECCPoint) ECCPoint {
+	return c.toAffine(c.add(c.toJacobian(a), c.toJacobian(b)))
+}
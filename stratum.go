@@ -0,0 +1,429 @@
+package ozcoin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	STRATUM_SHARE_FACTOR  = 256 // shares are this many times easier than blocks
+	STRATUM_JOB_INTERVAL  = 30 * time.Second
+	STRATUM_MAX_JOBS      = 4 // jobs kept per worker between clean jobs
+	STRATUM_WRITE_TIMEOUT = 10 * time.Second
+)
+
+// Stratum error codes
+const (
+	STRATUM_ERR_OTHER         = 20
+	STRATUM_ERR_JOB_NOT_FOUND = 21
+	STRATUM_ERR_DUPLICATE     = 22
+	STRATUM_ERR_LOW_DIFF      = 23
+	STRATUM_ERR_UNAUTHORIZED  = 24
+)
+
+var MAX_TARGET = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+/*
+ * StratumServer
+ *
+ * Pools workers against one node over line-delimited json-rpc.  Every worker is
+ * given its own coinbase extra-nonce, so no two workers ever search the same
+ * header.  Shares are accepted at `ShareFactor` times below the block
+ * difficulty, and full solutions are adopted through `BlockChan`.
+ */
+type StratumServer struct {
+	*Miner
+	Address      string
+	Payout       WalletPublicKey
+	ShareFactor  int64
+	WriteTimeout time.Duration
+	tipChan      <-chan SHA256Sum
+	mu           sync.Mutex
+	workers      map[uint64]*stratumWorker
+	nextWorker   uint64
+	nextJob      uint64
+	template     Block
+	shares       map[string]uint64
+	rejected     map[string]uint64
+}
+
+type stratumWorker struct {
+	extraNonce   uint64
+	name         string
+	conn         net.Conn
+	writeTimeout time.Duration
+	writeMu      sync.Mutex
+	jobs         map[string]*stratumJob
+	jobIDs       []string // oldest first
+}
+
+type stratumJob struct {
+	block       Block
+	target      *big.Int
+	shareTarget *big.Int
+	nonces      map[uint64]struct{}
+}
+
+type StratumRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type StratumResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  interface{}     `json:"error"`
+}
+
+type StratumNotification struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params []interface{}   `json:"params"`
+}
+
+/*
+ * Builds a stratum server paying block rewards to `payout`.
+ */
+func NewStratumServer(m *Miner, address string, payout WalletPublicKey) *StratumServer {
+	return &StratumServer{
+		Miner:        m,
+		Address:      address,
+		Payout:       payout,
+		ShareFactor:  STRATUM_SHARE_FACTOR,
+		WriteTimeout: STRATUM_WRITE_TIMEOUT,
+		tipChan:      m.SubscribeTip(),
+		workers:      make(map[uint64]*stratumWorker),
+		shares:       make(map[string]uint64),
+		rejected:     make(map[string]uint64),
+	}
+}
+
+/*
+ * Starts accepting workers and handing out jobs.
+ */
+func (s *StratumServer) Start() error {
+	l, err := net.Listen("tcp", s.Address)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Stratum server listening", s.Address)
+
+	s.refreshJobs(true)
+	go s.runJobs()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Println("Stratum accept failed:", err)
+				return
+			}
+
+			go s.handleConn(conn)
+		}
+	}()
+
+	return nil
+}
+
+/*
+ * Accepted and rejected share counts by worker name.
+ */
+func (s *StratumServer) ShareCounts() (map[string]uint64, map[string]uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	shares := make(map[string]uint64)
+	for name, n := range s.shares {
+		shares[name] = n
+	}
+
+	rejected := make(map[string]uint64)
+	for name, n := range s.rejected {
+		rejected[name] = n
+	}
+
+	return shares, rejected
+}
+
+/*
+ * Sends clean jobs whenever the tip moves, and refreshes txns and block time
+ * every `STRATUM_JOB_INTERVAL`.
+ */
+func (s *StratumServer) runJobs() {
+	for {
+		select {
+		case <-s.tipChan:
+			s.refreshJobs(true)
+		case <-time.After(STRATUM_JOB_INTERVAL):
+			s.refreshJobs(false)
+		}
+	}
+}
+
+/*
+ * Builds a new template and sends every authorized worker a job from it.
+ * Clean jobs invalidate the workers' previous jobs.  Workers are sent jobs
+ * concurrently, so a stalled worker only delays itself.
+ */
+func (s *StratumServer) refreshJobs(clean bool) {
+	block := s.RequestBlockTemplate().Block(s.Payout)
+
+	s.mu.Lock()
+	s.template = block
+	workers := []*stratumWorker{}
+	for _, w := range s.workers {
+		if w.name != "" {
+			workers = append(workers, w)
+		}
+	}
+	s.mu.Unlock()
+
+	wg := &sync.WaitGroup{}
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *stratumWorker) {
+			defer wg.Done()
+			s.sendJob(w, clean)
+		}(w)
+	}
+	wg.Wait()
+}
+
+/*
+ * Derives the worker's block from the current template by setting its
+ * extra-nonce, then notifies the worker of the share target and job.  Only the
+ * last `STRATUM_MAX_JOBS` jobs are kept, as each holds a whole block.
+ */
+func (s *StratumServer) sendJob(w *stratumWorker, clean bool) {
+	s.mu.Lock()
+	block := s.template
+	block.Txns = append([]Txn{}, block.Txns...)
+	block.Txns[0].Body.ExtraNonce = w.extraNonce
+	block.Header.MerkleRoot = block.MerkleHash()
+
+	target := block.Header.Target()
+	shareTarget := new(big.Int).Mul(target, big.NewInt(s.ShareFactor))
+	if shareTarget.Cmp(MAX_TARGET) > 0 {
+		shareTarget.Set(MAX_TARGET)
+	}
+
+	s.nextJob++
+	jobID := strconv.FormatUint(s.nextJob, 16)
+	if clean {
+		w.jobs = make(map[string]*stratumJob)
+		w.jobIDs = nil
+	}
+	w.jobs[jobID] = &stratumJob{
+		block:       block,
+		target:      target,
+		shareTarget: shareTarget,
+		nonces:      make(map[uint64]struct{}),
+	}
+	w.jobIDs = append(w.jobIDs, jobID)
+	if len(w.jobIDs) > STRATUM_MAX_JOBS {
+		delete(w.jobs, w.jobIDs[0])
+		w.jobIDs = w.jobIDs[1:]
+	}
+	s.mu.Unlock()
+
+	w.send(StratumNotification{
+		Method: "mining.set_target",
+		Params: []interface{}{fmt.Sprintf("%064x", shareTarget)},
+	})
+	w.send(StratumNotification{
+		Method: "mining.notify",
+		Params: []interface{}{jobID, block.Header, clean},
+	})
+}
+
+/*
+ * Serves one worker connection until it closes.
+ */
+func (s *StratumServer) handleConn(conn net.Conn) {
+	defer conn.Close()
+
+	s.mu.Lock()
+	s.nextWorker++
+	w := &stratumWorker{
+		extraNonce:   s.nextWorker,
+		conn:         conn,
+		writeTimeout: s.WriteTimeout,
+		jobs:         make(map[string]*stratumJob),
+	}
+	s.workers[w.extraNonce] = w
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.workers, w.extraNonce)
+		s.mu.Unlock()
+	}()
+
+	log.Println("Stratum worker connected:", conn.RemoteAddr())
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var req StratumRequest
+		err := json.Unmarshal(scanner.Bytes(), &req)
+		if err != nil {
+			log.Println("Malformed stratum request:", err)
+			return
+		}
+
+		switch req.Method {
+		case "mining.subscribe":
+			w.reply(req, []interface{}{"ozcoin", fmt.Sprintf("%016x", w.extraNonce)}, nil)
+
+		case "mining.authorize":
+			var name string
+			if len(req.Params) == 0 || json.Unmarshal(req.Params[0], &name) != nil || name == "" {
+				w.reply(req, false, stratumError(STRATUM_ERR_UNAUTHORIZED, "Missing worker name"))
+				continue
+			}
+
+			s.mu.Lock()
+			w.name = name
+			s.mu.Unlock()
+
+			w.reply(req, true, nil)
+			s.sendJob(w, true)
+
+		case "mining.submit":
+			err := s.submitShare(w, req)
+			if err != nil {
+				w.reply(req, false, err)
+				continue
+			}
+			w.reply(req, true, nil)
+
+		default:
+			w.reply(req, nil, stratumError(STRATUM_ERR_OTHER, "Unknown method"))
+		}
+	}
+}
+
+/*
+ * Checks a share submitted as [worker name, job id, hex nonce].  Shares that
+ * also meet the block target are sent to `BlockChan`.
+ */
+func (s *StratumServer) submitShare(w *stratumWorker, req StratumRequest) []interface{} {
+	s.mu.Lock()
+	name := w.name
+	s.mu.Unlock()
+
+	if name == "" {
+		return stratumError(STRATUM_ERR_UNAUTHORIZED, "Unauthorized worker")
+	}
+
+	var params [3]string
+	if len(req.Params) != len(params) {
+		return stratumError(STRATUM_ERR_OTHER, "Expected worker name, job id, and nonce")
+	}
+	for i := range params {
+		if json.Unmarshal(req.Params[i], &params[i]) != nil {
+			return stratumError(STRATUM_ERR_OTHER, "Params must be strings")
+		}
+	}
+
+	nonce, err := strconv.ParseUint(params[2], 16, 64)
+	if err != nil {
+		return stratumError(STRATUM_ERR_OTHER, "Invalid nonce")
+	}
+
+	block, target, rejection := s.checkShare(w, name, params[1], nonce)
+	if rejection != nil {
+		return rejection
+	}
+
+	if block.Header.Hash().Int().Cmp(target) <= 0 {
+		log.Println("Pool block found by", name)
+
+		// The share stands even if the block lost a race for the tip
+		err := s.SubmitBlock(block)
+		if err != nil {
+			log.Println("Pool block rejected:", err)
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Records the share as accepted or rejected.  Accepted shares return the solved
+ * block and its block target.
+ */
+func (s *StratumServer) checkShare(w *stratumWorker, name, jobID string, nonce uint64) (Block, *big.Int, []interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := w.jobs[jobID]
+	if !ok {
+		s.rejected[name]++
+		return Block{}, nil, stratumError(STRATUM_ERR_JOB_NOT_FOUND, "Job not found")
+	}
+
+	if _, ok := job.nonces[nonce]; ok {
+		s.rejected[name]++
+		return Block{}, nil, stratumError(STRATUM_ERR_DUPLICATE, "Duplicate share")
+	}
+
+	block := job.block
+	block.Header.Nonce = nonce
+	if block.Header.Hash().Int().Cmp(job.shareTarget) > 0 {
+		s.rejected[name]++
+		return Block{}, nil, stratumError(STRATUM_ERR_LOW_DIFF, "Low difficulty share")
+	}
+
+	job.nonces[nonce] = SIGNAL
+	s.shares[name]++
+
+	return block, job.target, nil
+}
+
+func stratumError(code int, msg string) []interface{} {
+	return []interface{}{code, msg, nil}
+}
+
+func (w *stratumWorker) reply(req StratumRequest, result interface{}, err []interface{}) {
+	res := StratumResponse{
+		ID:     req.ID,
+		Result: result,
+	}
+	if err != nil {
+		res.Error = err
+	}
+
+	w.send(res)
+}
+
+/*
+ * Writes one json message per line.  A worker that stops reading is
+ * disconnected once the write times out.
+ */
+func (w *stratumWorker) send(msg interface{}) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+	_, err = w.conn.Write(append(b, '\n'))
+	if err != nil {
+		log.Println("Stratum write failed:", err)
+		w.conn.Close()
+	}
+}
@@ -0,0 +1,394 @@
+package ozcoin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+/*
+ * A worker speaking to a stratum server over an in-memory pipe.  The pipe is
+ * unbuffered, so every message the server sends must be read before the next
+ * request is written.
+ */
+type testStratumWorker struct {
+	conn        net.Conn
+	scanner     *bufio.Scanner
+	nextID      int
+	shareTarget *big.Int
+	jobID       string
+	header      BlockHeader
+}
+
+type testStratumMessage struct {
+	ID     int               `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+	Error  []interface{}     `json:"error"`
+}
+
+func newTestStratumServer(c *Client) *StratumServer {
+	s := NewStratumServer(&Miner{Client: c}, "", NewPrivateKey().PublicKey())
+
+	// Blocks are 2^16 times harder than the regtest limit, shares 2^8
+	s.template = c.NewBlockTemplate(c.LastHeader).Block(s.Payout)
+	s.template.Header.Bits = 0x1f00ffff
+
+	return s
+}
+
+func newTestStratumWorker(s *StratumServer) *testStratumWorker {
+	server, conn := net.Pipe()
+	go s.handleConn(server)
+
+	return &testStratumWorker{
+		conn:    conn,
+		scanner: bufio.NewScanner(conn),
+	}
+}
+
+func (w *testStratumWorker) read(t *testing.T) testStratumMessage {
+	if !w.scanner.Scan() {
+		t.Fatal("Stratum connection closed:", w.scanner.Err())
+	}
+
+	var msg testStratumMessage
+	err := json.Unmarshal(w.scanner.Bytes(), &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return msg
+}
+
+func (w *testStratumWorker) send(t *testing.T, method string, params ...string) {
+	w.nextID++
+	req, err := json.Marshal(map[string]interface{}{
+		"id":     w.nextID,
+		"method": method,
+		"params": params,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = w.conn.Write(append(req, '\n'))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (w *testStratumWorker) call(t *testing.T, method string, params ...string) testStratumMessage {
+	w.send(t, method, params...)
+
+	res := w.read(t)
+	if res.ID != w.nextID {
+		t.Fatalf("Expected response %d, got %d", w.nextID, res.ID)
+	}
+
+	return res
+}
+
+/*
+ * Reads the share target and job sent after authorizing or a new tip.
+ */
+func (w *testStratumWorker) readJob(t *testing.T) {
+	var target string
+	msg := w.read(t)
+	if msg.Method != "mining.set_target" || json.Unmarshal(msg.Params[0], &target) != nil {
+		t.Fatal("Expected share target, got", msg.Method)
+	}
+
+	var ok bool
+	w.shareTarget, ok = new(big.Int).SetString(target, 16)
+	if !ok {
+		t.Fatal("Invalid share target:", target)
+	}
+
+	msg = w.read(t)
+	if msg.Method != "mining.notify" {
+		t.Fatal("Expected job, got", msg.Method)
+	}
+
+	err := json.Unmarshal(msg.Params[0], &w.jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(msg.Params[1], &w.header)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (w *testStratumWorker) login(t *testing.T, name string) string {
+	res := w.call(t, "mining.subscribe")
+
+	var sub []string
+	err := json.Unmarshal(res.Result, &sub)
+	if err != nil || len(sub) != 2 {
+		t.Fatal("Invalid subscription:", string(res.Result))
+	}
+
+	res = w.call(t, "mining.authorize", name, "x")
+	if string(res.Result) != "true" {
+		t.Fatal("Worker not authorized:", res.Error)
+	}
+	w.readJob(t)
+
+	return sub[1]
+}
+
+/*
+ * Finds the first nonce from `start` whose hash satisfies `accept`.
+ */
+func (w *testStratumWorker) findNonce(start uint64, accept func(*big.Int) bool) uint64 {
+	header := w.header
+	for header.Nonce = start; !accept(header.Hash().Int()); header.Nonce++ {
+	}
+
+	return header.Nonce
+}
+
+func (w *testStratumWorker) submit(t *testing.T, name string, nonce uint64) testStratumMessage {
+	return w.call(t, "mining.submit", name, w.jobID, fmt.Sprintf("%x", nonce))
+}
+
+func expectStratumError(t *testing.T, res testStratumMessage, code int) {
+	if string(res.Result) != "false" || len(res.Error) == 0 {
+		t.Errorf("Expected error %d, share accepted", code)
+		return
+	}
+
+	if int(res.Error[0].(float64)) != code {
+		t.Errorf("Expected error %d, got %v", code, res.Error)
+	}
+}
+
+func TestStratumExtraNonces(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestStratumServer(c)
+
+	w1 := newTestStratumWorker(s)
+	defer w1.conn.Close()
+	w2 := newTestStratumWorker(s)
+	defer w2.conn.Close()
+
+	extraNonce1 := w1.login(t, "w1")
+	extraNonce2 := w2.login(t, "w2")
+
+	if extraNonce1 == extraNonce2 {
+		t.Error("Workers share extra-nonce", extraNonce1)
+	}
+
+	// Distinct coinbases commit to distinct headers
+	if w1.header.MerkleRoot == w2.header.MerkleRoot {
+		t.Error("Workers were given the same merkle root")
+	}
+	if w1.header.PrevHash != c.LastHeader.Hash() || w2.header.PrevHash != c.LastHeader.Hash() {
+		t.Error("Jobs do not extend the tip")
+	}
+
+	// A new template reaches every worker with their own extra-nonce
+	s.mu.Lock()
+	workers := []*stratumWorker{}
+	for _, w := range s.workers {
+		workers = append(workers, w)
+	}
+	s.mu.Unlock()
+
+	for _, w := range workers {
+		go s.sendJob(w, true)
+	}
+	w1.readJob(t)
+	w2.readJob(t)
+
+	if w1.header.MerkleRoot == w2.header.MerkleRoot {
+		t.Error("Refreshed jobs share a merkle root")
+	}
+}
+
+func TestStratumShares(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestStratumServer(c)
+	w := newTestStratumWorker(s)
+	defer w.conn.Close()
+
+	w.login(t, "w1")
+	target := w.header.Target()
+
+	if w.shareTarget.Cmp(target) <= 0 {
+		t.Fatal("Share target not easier than block target")
+	}
+
+	low := w.findNonce(0, func(h *big.Int) bool {
+		return h.Cmp(w.shareTarget) > 0
+	})
+	expectStratumError(t, w.submit(t, "w1", low), STRATUM_ERR_LOW_DIFF)
+
+	share := w.findNonce(0, func(h *big.Int) bool {
+		return h.Cmp(w.shareTarget) <= 0 && h.Cmp(target) > 0
+	})
+	res := w.submit(t, "w1", share)
+	if string(res.Result) != "true" {
+		t.Fatal("Valid share rejected:", res.Error)
+	}
+
+	expectStratumError(t, w.submit(t, "w1", share), STRATUM_ERR_DUPLICATE)
+
+	res = w.call(t, "mining.submit", "w1", "ffff", fmt.Sprintf("%x", share))
+	expectStratumError(t, res, STRATUM_ERR_JOB_NOT_FOUND)
+
+	// A full solution is handed to the client before the share is acknowledged
+	solution := w.findNonce(0, func(h *big.Int) bool {
+		return h.Cmp(target) <= 0
+	})
+	w.send(t, "mining.submit", "w1", w.jobID, fmt.Sprintf("%x", solution))
+
+	solved := w.header
+	solved.Nonce = solution
+
+	select {
+	case sub := <-c.BlockChan:
+		if sub.Block.Header.Hash() != solved.Hash() {
+			t.Error("Solved block does not match the worker's job")
+		}
+		if !sub.Block.VerifyMerkleHash() {
+			t.Error("Solved block has an invalid merkle root")
+		}
+		sub.Result <- nil
+	case <-time.After(5 * time.Second):
+		t.Fatal("Solution never reached BlockChan")
+	}
+
+	res = w.read(t)
+	if string(res.Result) != "true" {
+		t.Error("Solution rejected:", res.Error)
+	}
+
+	shares, rejected := s.ShareCounts()
+	if shares["w1"] != 2 {
+		t.Error("Expected 2 accepted shares, got", shares["w1"])
+	}
+	if rejected["w1"] != 3 {
+		t.Error("Expected 3 rejected shares, got", rejected["w1"])
+	}
+}
+
+func TestStratumStaleJobs(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestStratumServer(c)
+	w := newTestStratumWorker(s)
+	defer w.conn.Close()
+
+	w.login(t, "w1")
+	first := w.jobID
+
+	s.mu.Lock()
+	var worker *stratumWorker
+	for _, sw := range s.workers {
+		worker = sw
+	}
+	s.mu.Unlock()
+
+	// Refreshed jobs pile up until the oldest is dropped
+	for i := 0; i < STRATUM_MAX_JOBS; i++ {
+		go s.sendJob(worker, false)
+		w.readJob(t)
+	}
+
+	s.mu.Lock()
+	jobs := len(worker.jobs)
+	s.mu.Unlock()
+	if jobs != STRATUM_MAX_JOBS {
+		t.Errorf("Expected %d jobs kept, got %d", STRATUM_MAX_JOBS, jobs)
+	}
+
+	share := w.findNonce(0, func(h *big.Int) bool {
+		return h.Cmp(w.shareTarget) <= 0
+	})
+	res := w.call(t, "mining.submit", "w1", first, fmt.Sprintf("%x", share))
+	expectStratumError(t, res, STRATUM_ERR_JOB_NOT_FOUND)
+
+	// A clean job drops every stale job
+	go s.sendJob(worker, true)
+	w.readJob(t)
+
+	s.mu.Lock()
+	jobs = len(worker.jobs)
+	s.mu.Unlock()
+	if jobs != 1 {
+		t.Error("Expected only the clean job, got", jobs)
+	}
+}
+
+func TestStratumStalledWorker(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go c.run()
+
+	s := newTestStratumServer(c)
+	s.WriteTimeout = 100 * time.Millisecond
+
+	stalled := newTestStratumWorker(s)
+	defer stalled.conn.Close()
+	w := newTestStratumWorker(s)
+	defer w.conn.Close()
+
+	stalled.login(t, "stalled")
+	w.login(t, "w1")
+
+	// The stalled worker never reads its job
+	done := make(chan struct{})
+	go func() {
+		s.refreshJobs(false)
+		close(done)
+	}()
+
+	w.readJob(t)
+	if w.header.PrevHash != c.LastHeader.Hash() {
+		t.Error("Job does not extend the tip")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Refresh blocked on a stalled worker")
+	}
+
+	// and is disconnected
+	if stalled.scanner.Scan() {
+		t.Error("Stalled worker still connected")
+	}
+}
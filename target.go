@@ -0,0 +1,91 @@
+package ozcoin
+
+import (
+	"math/big"
+)
+
+/*
+ * Compact Targets
+ *
+ * Block targets are stored in the header in the same compact form as Bitcoin's
+ * nBits: the high byte is the length of the target in bytes, and the low three
+ * bytes are its most significant bytes.  Bit 23 is the sign bit.
+ */
+
+/*
+ * Expands a compact target into a 256-bit integer.
+ */
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := int64(compact & 0x007fffff)
+	negative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	n := &big.Int{}
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		n.SetInt64(mantissa)
+	} else {
+		n.SetInt64(mantissa)
+		n.Lsh(n, 8*(exponent-3))
+	}
+
+	if negative {
+		n.Neg(n)
+	}
+
+	return n
+}
+
+/*
+ * Converts an integer into its compact form, dropping all but its three most
+ * significant bytes.
+ */
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	abs := &big.Int{}
+	abs.Abs(n)
+
+	exponent := uint(len(abs.Bytes()))
+	mantissa := uint32(0)
+	if exponent <= 3 {
+		mantissa = uint32(abs.Uint64()) << (8 * (3 - exponent))
+	} else {
+		abs.Rsh(abs, 8*(exponent-3))
+		mantissa = uint32(abs.Uint64())
+	}
+
+	// Keep the sign bit clear by moving into the next exponent
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+
+	return compact
+}
+
+/*
+ * The expected number of hashes needed to meet `target`,
+ * 2^256 / (target + 1).
+ */
+func CalcWork(target *big.Int) *big.Int {
+	if target.Sign() <= 0 {
+		return &big.Int{}
+	}
+
+	denominator := &big.Int{}
+	denominator.Add(target, big.NewInt(1))
+
+	work := big.NewInt(1)
+	work.Lsh(work, 256)
+	work.Div(work, denominator)
+
+	return work
+}
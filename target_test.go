@@ -0,0 +1,53 @@
+package ozcoin
+
+import (
+	"math/big"
+	"testing"
+)
+
+type compactInput struct {
+	Compact uint32
+	Hex     string
+}
+
+var compactInputs = []compactInput{
+	compactInput{0x00000000, "0"},
+	compactInput{0x01010000, "1"},
+	compactInput{0x02008000, "80"},
+	compactInput{0x03123456, "123456"},
+	compactInput{0x04123456, "12345600"},
+	compactInput{0x05009234, "92340000"},
+	compactInput{0x04923456, "-12345600"},
+	compactInput{0x1d00ffff, "ffff0000000000000000000000000000000000000000000000000000"},
+	compactInput{0x1f00ffff, "ffff00000000000000000000000000000000000000000000000000000000"},
+}
+
+func TestCompactRoundTrip(t *testing.T) {
+	for i, inp := range compactInputs {
+		exp, _ := new(big.Int).SetString(inp.Hex, 16)
+
+		n := CompactToBig(inp.Compact)
+		if n.Cmp(exp) != 0 {
+			t.Error("Compact", i, "expanded to", n.Text(16))
+		}
+
+		if BigToCompact(exp) != inp.Compact {
+			t.Errorf("Target %d compacted to %08x", i, BigToCompact(exp))
+		}
+	}
+}
+
+func TestCalcWork(t *testing.T) {
+	// A target of 2^240 - 1 should take 2^16 hashes
+	target := big.NewInt(1)
+	target.Lsh(target, 240)
+	target.Sub(target, big.NewInt(1))
+
+	if CalcWork(target).Cmp(big.NewInt(1<<16)) != 0 {
+		t.Error("Unexpected work:", CalcWork(target))
+	}
+
+	if CalcWork(&big.Int{}).Sign() != 0 {
+		t.Error("Zero target should have no work")
+	}
+}
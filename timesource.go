@@ -0,0 +1,142 @@
+package ozcoin
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	MEDIAN_TIME_SPAN = 11               // Headers used for median-time-past
+	MAX_FUTURE_DRIFT = 2 * time.Hour    // Default allowance for future blocks
+	MAX_TIME_OFFSET  = 70 * time.Minute // Largest peer adjustment to local time
+	MIN_TIME_SAMPLES = 5                // Peers required before adjusting
+	MAX_TIME_SAMPLES = 200              // Peers sampled, later peers are ignored
+)
+
+/*
+ * MedianTimeSource
+ *
+ * Tracks the clock offset reported by each peer.  Network-adjusted time is the
+ * local time plus the median peer offset, provided enough peers have reported
+ * and the median is within `MAX_TIME_OFFSET`.  Peers are identified by the
+ * remote host of their connection rather than anything they report, each is
+ * sampled once, and at most `MAX_TIME_SAMPLES` are kept.
+ */
+type MedianTimeSource struct {
+	mtx     sync.Mutex
+	offsets map[string]time.Duration
+}
+
+func NewMedianTimeSource() *MedianTimeSource {
+	return &MedianTimeSource{
+		offsets: make(map[string]time.Duration),
+	}
+}
+
+/*
+ * Records the difference between a peer's reported time and local time, unless
+ * the peer was already sampled or the source is full.
+ */
+func (m *MedianTimeSource) AddTimeSample(peer string, peerTime time.Time) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if _, ok := m.offsets[peer]; ok {
+		return
+	}
+
+	if len(m.offsets) >= MAX_TIME_SAMPLES {
+		return
+	}
+
+	m.offsets[peer] = peerTime.Sub(time.Now())
+}
+
+/*
+ * The median peer offset, or 0 if there are too few peers or they disagree
+ * with local time by too much.
+ */
+func (m *MedianTimeSource) Offset() time.Duration {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if len(m.offsets) < MIN_TIME_SAMPLES {
+		return 0
+	}
+
+	offsets := []time.Duration{}
+	for _, offset := range m.offsets {
+		offsets = append(offsets, offset)
+	}
+	sort.Sort(durations(offsets))
+
+	median := offsets[len(offsets)/2]
+	if median > MAX_TIME_OFFSET || median < -MAX_TIME_OFFSET {
+		return 0
+	}
+
+	return median
+}
+
+func (m *MedianTimeSource) AdjustedTime() time.Time {
+	return time.Now().Add(m.Offset())
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+/*
+ * Computes the median time of the `MEDIAN_TIME_SPAN` headers ending in `hash`,
+ * following side chain headers back to the main chain where necessary.
+ */
+func (c *Client) MedianTimePast(hash SHA256Sum) (time.Time, error) {
+	times := []time.Time{}
+	prevHash := hash
+	for len(times) < MEDIAN_TIME_SPAN {
+		header, err := c.GetHeader(prevHash)
+		if err != nil {
+			header, err = c.GetSideHeader(prevHash)
+			if err != nil {
+				return time.Time{}, err
+			}
+		}
+
+		times = append(times, header.Time)
+
+		if header.SeqNum == 0 {
+			break
+		}
+		prevHash = header.PrevHash
+	}
+
+	sort.Sort(timestamps(times))
+
+	return times[len(times)/2], nil
+}
+
+type timestamps []time.Time
+
+func (t timestamps) Len() int           { return len(t) }
+func (t timestamps) Less(i, j int) bool { return t[i].Before(t[j]) }
+func (t timestamps) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+
+/*
+ * Checks that a block's time is later than the median-time-past of its
+ * ancestors.  The genesis block has no ancestors and is always accepted.
+ */
+func (c *Client) ValidMedianTime(header BlockHeader) bool {
+	if header.SeqNum == 0 {
+		return true
+	}
+
+	mtp, err := c.MedianTimePast(header.PrevHash)
+	if err != nil {
+		return false
+	}
+
+	return header.Time.After(mtp)
+}
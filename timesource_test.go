@@ -0,0 +1,237 @@
+package ozcoin
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+var testEpoch = time.Unix(1450000000, 0)
+
+/*
+ * Stores a chain of headers extending `prev` with the given times, in seconds
+ * after `testEpoch`, to either the main or side header database.
+ */
+func putTestHeaders(t *testing.T, c *Client, prev *BlockHeader, secs []int64, side bool) []BlockHeader {
+	headers := []BlockHeader{}
+	for _, sec := range secs {
+		header := BlockHeader{
+			Time: testEpoch.Add(time.Duration(sec) * time.Second),
+			Bits: RegTestParams.PowLimitBits,
+		}
+		if prev != nil {
+			header.SeqNum = prev.SeqNum + 1
+			header.PrevHash = prev.Hash()
+		}
+
+		var err error
+		if side {
+			err = c.PutSideHeader(header)
+		} else {
+			err = c.PutHeader(header)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		headers = append(headers, header)
+		prev = &headers[len(headers)-1]
+	}
+
+	return headers
+}
+
+func TestMedianTimePast(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	// Only the last 11 of these count, whose median is 50
+	main := putTestHeaders(t, c, nil,
+		[]int64{1000, 1000, 10, 90, 20, 80, 30, 70, 40, 60, 50, 100, 0}, false)
+
+	mtp, err := c.MedianTimePast(main[len(main)-1].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mtp.Equal(testEpoch.Add(50 * time.Second)) {
+		t.Error("Unexpected median time past:", mtp)
+	}
+
+	// Fewer than 11 ancestors uses all of them
+	mtp, err = c.MedianTimePast(main[2].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mtp.Equal(testEpoch.Add(1000 * time.Second)) {
+		t.Error("Unexpected median time past near genesis:", mtp)
+	}
+}
+
+func TestMedianTimePastSideChain(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	main := putTestHeaders(t, c, nil,
+		[]int64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 110}, false)
+
+	// Fork after 80, the side headers push the median past the main chain's
+	side := putTestHeaders(t, c, &main[8], []int64{500, 600, 700, 800}, true)
+
+	mainMTP, err := c.MedianTimePast(main[len(main)-1].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mainMTP.Equal(testEpoch.Add(60 * time.Second)) {
+		t.Error("Unexpected main median time past:", mainMTP)
+	}
+
+	// 800, 700, 600, 500, 80, 70, 60, 50, 40, 30, 20 has a median of 70
+	sideMTP, err := c.MedianTimePast(side[len(side)-1].Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sideMTP.Equal(testEpoch.Add(70 * time.Second)) {
+		t.Error("Unexpected side median time past:", sideMTP)
+	}
+
+	// Blocks extending the side chain are checked against side ancestors
+	next := BlockHeader{
+		SeqNum:   side[len(side)-1].SeqNum + 1,
+		PrevHash: side[len(side)-1].Hash(),
+		Time:     sideMTP,
+	}
+	if c.ValidMedianTime(next) {
+		t.Error("Block at median time past should be rejected")
+	}
+
+	next.Time = sideMTP.Add(time.Second)
+	if !c.ValidMedianTime(next) {
+		t.Error("Block after median time past should be accepted")
+	}
+
+	// A time valid on the main chain is too early for the side chain
+	next.Time = mainMTP.Add(time.Second)
+	if c.ValidMedianTime(next) {
+		t.Error("Side chain block should use side chain ancestors")
+	}
+}
+
+func TestFutureDrift(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	mine := func(blockTime time.Time) BlockHeader {
+		header := BlockHeader{
+			SeqNum: 1,
+			Time:   blockTime,
+			Bits:   RegTestParams.PowLimitBits,
+		}
+		for !header.ValidPoW() {
+			header.Nonce += 1
+		}
+
+		return header
+	}
+
+	now := time.Now()
+	if !c.ValidHeader(mine(now.Add(-3 * time.Hour))) {
+		t.Error("Header in the past should be accepted")
+	}
+	if !c.ValidHeader(mine(now.Add(MAX_FUTURE_DRIFT - time.Minute))) {
+		t.Error("Header within drift should be accepted")
+	}
+
+	ahead := mine(now.Add(MAX_FUTURE_DRIFT + 10*time.Minute))
+	if c.ValidHeader(ahead) {
+		t.Error("Header beyond drift should be rejected")
+	}
+
+	// Peers running 30 minutes ahead move network-adjusted time forward
+	for _, peer := range []string{"a", "b", "c", "d", "e"} {
+		c.TimeSource.AddTimeSample(peer, now.Add(30*time.Minute))
+	}
+	if !c.ValidHeader(ahead) {
+		t.Error("Header within drift of adjusted time should be accepted")
+	}
+
+	// Drift is configurable
+	c.Params.MaxFutureDrift = 10 * time.Minute
+	if c.ValidHeader(mine(now.Add(time.Hour))) {
+		t.Error("Header beyond configured drift should be rejected")
+	}
+}
+
+func TestMedianTimeSource(t *testing.T) {
+	m := NewMedianTimeSource()
+
+	// Too few samples leaves local time alone
+	for _, peer := range []string{"a", "b", "c", "d"} {
+		m.AddTimeSample(peer, time.Now().Add(2*time.Hour))
+	}
+	if m.Offset() != 0 {
+		t.Error("Offset applied with too few samples")
+	}
+
+	// Offsets larger than MAX_TIME_OFFSET are ignored
+	m.AddTimeSample("e", time.Now().Add(2*time.Hour))
+	if m.Offset() != 0 {
+		t.Error("Offset beyond MAX_TIME_OFFSET applied")
+	}
+
+	// Peers are only sampled once
+	for _, peer := range []string{"a", "b", "c", "d", "e"} {
+		m.AddTimeSample(peer, time.Now().Add(-time.Minute))
+	}
+	if m.Offset() != 0 {
+		t.Error("Resampled peer replaced its offset")
+	}
+
+	for _, peer := range []string{"f", "g", "h", "i", "j", "k"} {
+		m.AddTimeSample(peer, time.Now().Add(-time.Minute))
+	}
+	if m.Offset() > -59*time.Second || m.Offset() < -61*time.Second {
+		t.Error("Unexpected offset:", m.Offset())
+	}
+}
+
+func TestMedianTimeSourceCap(t *testing.T) {
+	m := NewMedianTimeSource()
+	for i := 0; i < MAX_TIME_SAMPLES; i++ {
+		m.AddTimeSample(fmt.Sprint("honest", i), time.Now())
+	}
+
+	// A flood of new peers cannot move the median once full
+	for i := 0; i < MAX_TIME_SAMPLES; i++ {
+		m.AddTimeSample(fmt.Sprint("sybil", i), time.Now().Add(time.Hour))
+	}
+
+	if len(m.offsets) != MAX_TIME_SAMPLES {
+		t.Errorf("Expected %d samples, got %d", MAX_TIME_SAMPLES, len(m.offsets))
+	}
+	if m.Offset() > time.Second {
+		t.Error("Samples beyond the cap moved the offset:", m.Offset())
+	}
+}
+
+func TestTimeSampleKeyedByConnection(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	// One host reporting many addresses is sampled once
+	for i := 0; i < MIN_TIME_SAMPLES; i++ {
+		req := RPCHeader{
+			Net:     c.Params.Net,
+			Address: fmt.Sprint("peer", i, ":26000"),
+			Time:    time.Now().Add(time.Hour),
+		}
+
+		err := c.HandleRPC("10.0.0.1", req, &RPCHeader{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(c.TimeSource.offsets) != 1 {
+		t.Errorf("Expected 1 sample, got %d", len(c.TimeSource.offsets))
+	}
+}
@@ -0,0 +1,317 @@
+package ozcoin
+
+import (
+	"bytes"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	MAX_POOL_SIZE = 32 * 1024 * 1024 // bytes of serialized txns
+	POOL_EXPIRY   = 72 * time.Hour
+)
+
+/*
+ * PoolEntry
+ *
+ * A txn waiting in the txn pool, along with when it was admitted and the main
+ * chain height at the time.
+ */
+type PoolEntry struct {
+	Txn    Txn       `json:"txn"`
+	Added  time.Time `json:"added"`
+	Height uint64    `json:"height"`
+}
+
+func NewPoolEntry(txn Txn, height uint64) PoolEntry {
+	return PoolEntry{
+		Txn:    txn,
+		Added:  time.Now(),
+		Height: height,
+	}
+}
+
+func (pe PoolEntry) MarshalBinary() ([]byte, error) {
+	e := newEncoder()
+	pe.Txn.encode(e)
+	e.putTime(pe.Added)
+	e.putUint64(pe.Height)
+
+	return e.bytes()
+}
+
+func (pe *PoolEntry) UnmarshalBinary(data []byte) error {
+	d := newDecoder(data)
+	pe.Txn.decode(d)
+	pe.Added = d.getTime()
+	pe.Height = d.getUint64()
+
+	return d.finish()
+}
+
+func (pe PoolEntry) Bytes() []byte {
+	return mustEncode(pe.MarshalBinary())
+}
+
+/*
+ * Checks whether an entry has waited longer than `PoolExpiry`.
+ */
+func (c *Client) PoolEntryExpired(entry PoolEntry) bool {
+	return c.poolExpired(entry.Added)
+}
+
+func (c *Client) poolExpired(added time.Time) bool {
+	return time.Since(added) > c.PoolExpiry
+}
+
+/*
+ * Pool Index
+ *
+ * An in-memory summary of every entry in the txn pool database, kept in step
+ * with it on every put and delete.  Admission, eviction, pruning and
+ * `PoolInfo` work from the index so they never decode the whole pool.
+ */
+type poolIndexEntry struct {
+	hash     SHA256Sum
+	pimgHash SHA256Sum
+	inputs   []SHA256Sum
+	fee      uint64
+	size     uint64
+	added    time.Time
+	height   uint64
+}
+
+type poolIndex struct {
+	mtx     sync.Mutex
+	entries map[SHA256Sum]poolIndexEntry
+}
+
+func newPoolIndex() *poolIndex {
+	return &poolIndex{
+		entries: make(map[SHA256Sum]poolIndexEntry),
+	}
+}
+
+func (pi *poolIndex) put(entry PoolEntry) {
+	pi.mtx.Lock()
+	defer pi.mtx.Unlock()
+
+	txn := entry.Txn
+	hash := txn.Hash()
+	pi.entries[hash] = poolIndexEntry{
+		hash:     hash,
+		pimgHash: Hash(txn.Sig.Preimage.Bytes()),
+		inputs:   txn.Body.Inputs,
+		fee:      txn.Body.Fee,
+		size:     uint64(txn.Size()),
+		added:    entry.Added,
+		height:   entry.Height,
+	}
+}
+
+func (pi *poolIndex) remove(hash SHA256Sum) {
+	pi.mtx.Lock()
+	defer pi.mtx.Unlock()
+
+	delete(pi.entries, hash)
+}
+
+/*
+ * Copies every entry, so callers may delete from the pool while iterating.
+ */
+func (pi *poolIndex) snapshot() []poolIndexEntry {
+	pi.mtx.Lock()
+	defer pi.mtx.Unlock()
+
+	entries := make([]poolIndexEntry, 0, len(pi.entries))
+	for _, entry := range pi.entries {
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+/*
+ * Sorts cheapest first, the reverse of `byFeeRate`.
+ */
+type byPoolFeeRate []poolIndexEntry
+
+func (t byPoolFeeRate) Len() int           { return len(t) }
+func (t byPoolFeeRate) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t byPoolFeeRate) Less(i, j int) bool { return cheaperPoolEntry(t[i], t[j]) }
+
+func cheaperPoolEntry(a, b poolIndexEntry) bool {
+	cmp := compareRates(a.fee, a.size, b.fee, b.size)
+	if cmp != 0 {
+		return cmp < 0
+	}
+
+	return bytes.Compare(a.hash[:], b.hash[:]) > 0
+}
+
+/*
+ * TxnPoolInfo
+ *
+ * Summarizes the txn pool.  `MinFeeRate`, in fees per serialized byte, is zero
+ * until the pool is full.  Once full, a new txn must pay more than it to be
+ * admitted.
+ */
+type TxnPoolInfo struct {
+	Count      int     `json:"count"`
+	Size       int     `json:"size"`
+	MaxSize    int     `json:"max_size"`
+	MinFeeRate float64 `json:"min_fee_rate"`
+}
+
+func (c *Client) PoolInfo() TxnPoolInfo {
+	info := TxnPoolInfo{
+		MaxSize: c.MaxPoolSize,
+	}
+
+	entries := c.dbm.poolIndex.snapshot()
+	if len(entries) == 0 {
+		return info
+	}
+
+	cheapest := entries[0]
+	for _, entry := range entries {
+		info.Count++
+		info.Size += int(entry.size)
+
+		if cheaperPoolEntry(entry, cheapest) {
+			cheapest = entry
+		}
+	}
+
+	// Full once the cheapest txn could not be admitted again without eviction
+	if info.Size+int(cheapest.size) > c.MaxPoolSize {
+		info.MinFeeRate = float64(cheapest.fee) / float64(cheapest.size)
+	}
+
+	return info
+}
+
+/*
+ * Removes expired txns, then evicts the lowest fee rate txns until the pool
+ * fits within `MaxPoolSize`.  Returns the hashes of every removed txn.
+ */
+func (c *Client) TrimTxnPool() (map[SHA256Sum]struct{}, error) {
+	removed := make(map[SHA256Sum]struct{})
+
+	entries := []poolIndexEntry{}
+	size := 0
+	for _, entry := range c.dbm.poolIndex.snapshot() {
+		if c.poolExpired(entry.added) {
+			log.Println("Expiring pool txn")
+			err := c.deletePoolEntry(entry.hash, entry.pimgHash)
+			if err != nil {
+				return removed, err
+			}
+
+			removed[entry.hash] = SIGNAL
+			continue
+		}
+
+		entries = append(entries, entry)
+		size += int(entry.size)
+	}
+
+	if size <= c.MaxPoolSize {
+		return removed, nil
+	}
+
+	sort.Sort(byPoolFeeRate(entries))
+	for i := 0; size > c.MaxPoolSize && i < len(entries); i++ {
+		log.Println("Evicting pool txn")
+		err := c.deletePoolEntry(entries[i].hash, entries[i].pimgHash)
+		if err != nil {
+			return removed, err
+		}
+
+		removed[entries[i].hash] = SIGNAL
+		size -= int(entries[i].size)
+	}
+
+	return removed, nil
+}
+
+/*
+ * Removes pool txns that are no longer spendable on the main chain, either
+ * because an input was reorganized away or the preimage is now spent.
+ */
+func (c *Client) PruneTxnPool() error {
+	for _, entry := range c.dbm.poolIndex.snapshot() {
+		spendable := !c.GetPreimage(entry.pimgHash)
+		for _, inp := range entry.inputs {
+			_, err := c.MapToBlock(inp)
+			if err != nil {
+				spendable = false
+				break
+			}
+		}
+
+		if spendable {
+			continue
+		}
+
+		log.Println("Pruning unspendable pool txn")
+		err := c.deletePoolEntry(entry.hash, entry.pimgHash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Removes the pool txn, if any, spending the preimage `pimgHash`.
+ */
+func (c *Client) DeleteTxnPoolPreimage(pimgHash SHA256Sum) error {
+	hash, err := c.GetPoolPreimage(pimgHash)
+	if err != nil {
+		return nil
+	}
+
+	return c.deletePoolEntry(hash, pimgHash)
+}
+
+/*
+ * Removes every pool txn spending a preimage confirmed by `block`, including
+ * conflicting txns that were not themselves included.
+ */
+func (c *Client) RemoveConfirmedTxns(block Block) error {
+	for i, txn := range block.Txns {
+		// Skip the coinbase
+		if i == 0 {
+			continue
+		}
+
+		err := c.DeleteTxnPoolPreimage(Hash(txn.Sig.Preimage.Bytes()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ * Returns the txns of a block disconnected from the main chain to the pool.
+ * Txns that are no longer admissible are dropped.
+ */
+func (c *Client) RestoreDisconnectedTxns(block Block) {
+	for i, txn := range block.Txns {
+		// Skip the coinbase
+		if i == 0 {
+			continue
+		}
+
+		err := c.PutTxnPool(txn)
+		if err != nil {
+			log.Println("Could not restore txn to pool:", err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package ozcoin
+
+import (
+	"testing"
+)
+
+func TestTxnPoolEvictsLowestFeeRate(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	cheap := selectionTxn(10, 1, 1)
+	mid := selectionTxn(100, 2, 1)
+	rich := selectionTxn(1000, 3, 1)
+	c.MaxPoolSize = txnSize(mid, rich)
+
+	for _, txn := range []Txn{mid, rich} {
+		err := c.PutTxnPool(txn)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info := c.PoolInfo()
+	if info.Count != 2 || info.Size != txnSize(mid, rich) {
+		t.Errorf("Unexpected pool info: %+v", info)
+	}
+	if info.MinFeeRate <= 0 {
+		t.Error("Full pool reports no minimum fee rate")
+	}
+
+	err := c.PutTxnPool(cheap)
+	if err == nil {
+		t.Error("Cheap txn admitted to full pool")
+	}
+
+	_, err = c.GetTxnPool(cheap.Hash())
+	if err == nil {
+		t.Error("Cheap txn left in pool")
+	}
+
+	dearer := selectionTxn(10000, 4, 1)
+	err = c.PutTxnPool(dearer)
+	if err != nil {
+		t.Fatal("Dearer txn rejected:", err)
+	}
+
+	_, err = c.GetTxnPool(mid.Hash())
+	if err == nil {
+		t.Error("Lowest fee rate txn not evicted")
+	}
+
+	_, err = c.GetPoolPreimage(Hash(mid.Sig.Preimage.Bytes()))
+	if err == nil {
+		t.Error("Evicted txn left in preimage index")
+	}
+}
+
+func TestTxnPoolExpiry(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	txn := selectionTxn(100, 1, 1)
+	err := c.PutTxnPool(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.PoolExpiry = 0
+	if len(c.TxnsFromPool()) != 0 {
+		t.Error("Expired txn selected from pool")
+	}
+
+	removed, err := c.TrimTxnPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := removed[txn.Hash()]; !ok {
+		t.Error("Expired txn not trimmed")
+	}
+
+	if c.PoolInfo().Count != 0 {
+		t.Error("Expired txn left in pool")
+	}
+}
+
+func TestPruneTxnPool(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	// Inputs of a selection txn are not on the main chain
+	txn := selectionTxn(100, 1, 1)
+	err := c.PutTxnPool(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.PruneTxnPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.GetTxnPool(txn.Hash())
+	if err == nil {
+		t.Error("Txn with unknown inputs not pruned")
+	}
+}
+
+func TestPoolIndexTracksDatabase(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	cheap := selectionTxn(10, 1, 1)
+	rich := selectionTxn(1000, 2, 1)
+	replacement := selectionTxn(10000, 1, 1)
+
+	for _, txn := range []Txn{cheap, rich, replacement} {
+		err := c.PutTxnPool(txn)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := c.DeleteTxnPool(rich)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the replacement is left
+	info := c.PoolInfo()
+	if info.Count != 1 || info.Size != txnSize(replacement) {
+		t.Errorf("Unexpected pool info: %+v", info)
+	}
+
+	// Rebuilding from the database agrees with the running index
+	reloaded := loadPoolIndex(c.dbm.txnPoolDB).snapshot()
+	if len(reloaded) != 1 || reloaded[0].hash != replacement.Hash() {
+		t.Fatal("Reloaded pool index does not match database")
+	}
+
+	entry := reloaded[0]
+	if entry.fee != replacement.Body.Fee || entry.size != uint64(replacement.Size()) {
+		t.Errorf("Unexpected pool index entry: %+v", entry)
+	}
+}
@@ -0,0 +1,104 @@
+package ozcoin
+
+import (
+	"bytes"
+	"math/bits"
+	"sort"
+)
+
+const (
+	BLOCK_RESERVED_SIZE = 16 * 1024 // room for the header and coinbase txn
+)
+
+/*
+ * Chooses which pool txns go into a block.  Candidates are taken in order of
+ * fee per serialized byte, skipping any that would push the total past
+ * `maxSize` bytes or that reuse an already selected key preimage, until
+ * `maxTxns` have been chosen.
+ */
+func SelectTxns(candidates []Txn, maxSize, maxTxns int) []Txn {
+	txns := make([]sizedTxn, 0, len(candidates))
+	for _, txn := range candidates {
+		txns = append(txns, newSizedTxn(txn))
+	}
+
+	sort.Sort(byFeeRate(txns))
+
+	selected := []Txn{}
+	preimages := make(map[SHA256Sum]struct{})
+	total := uint64(0)
+	for _, st := range txns {
+		if len(selected) >= maxTxns {
+			break
+		}
+
+		if total+st.size > uint64(maxSize) {
+			continue
+		}
+
+		pimgHash := Hash(st.txn.Sig.Preimage.Bytes())
+		if _, ok := preimages[pimgHash]; ok {
+			continue
+		}
+
+		preimages[pimgHash] = SIGNAL
+		selected = append(selected, st.txn)
+		total += st.size
+	}
+
+	return selected
+}
+
+type sizedTxn struct {
+	txn  Txn
+	size uint64
+	hash SHA256Sum
+}
+
+func newSizedTxn(txn Txn) sizedTxn {
+	return sizedTxn{
+		txn:  txn,
+		size: uint64(txn.Size()),
+		hash: txn.Hash(),
+	}
+}
+
+/*
+ * Sorts by descending fee rate, then by hash so selection is deterministic.
+ */
+type byFeeRate []sizedTxn
+
+func (t byFeeRate) Len() int      { return len(t) }
+func (t byFeeRate) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t byFeeRate) Less(i, j int) bool {
+	cmp := compareRates(t[i].txn.Body.Fee, t[i].size, t[j].txn.Body.Fee, t[j].size)
+	if cmp != 0 {
+		return cmp > 0
+	}
+
+	return bytes.Compare(t[i].hash[:], t[j].hash[:]) < 0
+}
+
+/*
+ * Compares the fee per serialized byte of two txns, returning -1, 0, or 1.
+ */
+func compareFeeRate(a, b Txn) int {
+	return compareRates(a.Body.Fee, uint64(a.Size()), b.Body.Fee, uint64(b.Size()))
+}
+
+/*
+ * Compares feeA / sizeA with feeB / sizeB exactly, as feeA * sizeB against
+ * feeB * sizeA in 128 bits.
+ */
+func compareRates(feeA, sizeA, feeB, sizeB uint64) int {
+	hi1, lo1 := bits.Mul64(feeA, sizeB)
+	hi2, lo2 := bits.Mul64(feeB, sizeA)
+	switch {
+	case hi1 > hi2 || (hi1 == hi2 && lo1 > lo2):
+		return 1
+	case hi1 < hi2 || (hi1 == hi2 && lo1 < lo2):
+		return -1
+	}
+
+	return 0
+}
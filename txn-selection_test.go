@@ -0,0 +1,113 @@
+package ozcoin
+
+import (
+	"math/big"
+	"testing"
+)
+
+/*
+ * Builds a pool txn with the given fee and preimage `pimg*G`, padded with
+ * `inputs` empty inputs to control its serialized size.
+ */
+func selectionTxn(fee uint64, pimg int64, inputs int) Txn {
+
+	return Txn{
+		Body: TxnBody{
+			Inputs: make([]SHA256Sum, inputs),
+			Fee:    fee,
+		},
+		Sig: OZRS{
+			Preimage: CURVE.BaseMult(big.NewInt(pimg).Bytes()),
+		},
+	}
+}
+
+func txnSize(txns ...Txn) int {
+	size := 0
+	for _, txn := range txns {
+		size += txn.Size()
+	}
+
+	return size
+}
+
+func TestSelectTxnsByFeeRate(t *testing.T) {
+	small := selectionTxn(100, 1, 1)
+	large := selectionTxn(100, 2, 20)
+	rich := selectionTxn(10000, 3, 20)
+
+	selected := SelectTxns([]Txn{large, small, rich}, MAX_BLOCK_SIZE, MAX_BLOCK_TXNS)
+	expected := []Txn{rich, small, large}
+	if len(selected) != len(expected) {
+		t.Fatalf("Expected %d txns, got %d", len(expected), len(selected))
+	}
+
+	for i := range expected {
+		if selected[i].Hash() != expected[i].Hash() {
+			t.Errorf("Txn %d out of fee rate order", i)
+		}
+	}
+}
+
+func TestSelectTxnsSizeLimit(t *testing.T) {
+	best := selectionTxn(10000, 1, 1)
+	large := selectionTxn(5000, 2, 20)
+	small := selectionTxn(10, 3, 1)
+
+	// Room for everything but the large txn
+	maxSize := txnSize(best, small)
+	selected := SelectTxns([]Txn{small, large, best}, maxSize, MAX_BLOCK_TXNS)
+	if len(selected) != 2 {
+		t.Fatalf("Expected 2 txns, got %d", len(selected))
+	}
+
+	if selected[0].Hash() != best.Hash() || selected[1].Hash() != small.Hash() {
+		t.Error("Expected the large txn to be skipped")
+	}
+
+	if txnSize(selected...) > maxSize {
+		t.Error("Selection exceeds size limit")
+	}
+
+	if len(SelectTxns([]Txn{best}, txnSize(best)-1, MAX_BLOCK_TXNS)) != 0 {
+		t.Error("Selected txn larger than the limit")
+	}
+}
+
+func TestSelectTxnsDuplicatePreimage(t *testing.T) {
+	cheap := selectionTxn(100, 1, 1)
+	dear := selectionTxn(200, 1, 1)
+	other := selectionTxn(50, 2, 1)
+
+	selected := SelectTxns([]Txn{cheap, other, dear}, MAX_BLOCK_SIZE, MAX_BLOCK_TXNS)
+	if len(selected) != 2 {
+		t.Fatalf("Expected 2 txns, got %d", len(selected))
+	}
+
+	if selected[0].Hash() != dear.Hash() || selected[1].Hash() != other.Hash() {
+		t.Error("Expected the cheaper txn spending the same preimage to be dropped")
+	}
+}
+
+func TestSelectTxnsEmpty(t *testing.T) {
+	if len(SelectTxns(nil, MAX_BLOCK_SIZE, MAX_BLOCK_TXNS)) != 0 {
+		t.Error("Expected no txns")
+	}
+}
+
+func TestSelectTxnsCountLimit(t *testing.T) {
+	candidates := []Txn{
+		selectionTxn(300, 1, 1),
+		selectionTxn(200, 2, 1),
+		selectionTxn(100, 3, 1),
+	}
+
+	selected := SelectTxns(candidates, MAX_BLOCK_SIZE, 2)
+	if len(selected) != 2 {
+		t.Fatalf("Expected 2 txns, got %d", len(selected))
+	}
+
+	if selected[0].Hash() != candidates[0].Hash() || selected[1].Hash() != candidates[1].Hash() {
+		t.Error("Expected the two highest fee rate txns")
+	}
+}
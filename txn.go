@@ -8,7 +8,6 @@ import (
 )
 
 const (
-	TXN_NUM_INPUTS  = 8
 	TXN_NUM_OUTPUTS = 2
 )
 
@@ -16,7 +15,8 @@ const (
  * Txn
  *
  * Describes how transaction `Ouptut`s are to be transferred.  Each OZCoin txn
- * draws from 8 inputs to standardize anonymity. Each txn has exactly 2 outputs,
+ * draws from the network's `TxnNumInputs` inputs, 8 on mainnet, to standardize
+ * anonymity. Each txn has exactly 2 outputs,
  * which can be used to return the difference the sender.  This value can be 0
  * if the entire amount should be sent.
  */
@@ -32,9 +32,10 @@ type Txn struct {
  * The portion of the `Txn` to be signed.
  */
 type TxnBody struct {
-	Inputs  []SHA256Sum `json:"inputs"`
-	Outputs []Output    `json:"outputs"`
-	Fee     uint64      `json:"fee"`
+	Inputs     []SHA256Sum `json:"inputs"`
+	Outputs    []Output    `json:"outputs"`
+	Fee        uint64      `json:"fee"`
+	ExtraNonce uint64      `json:"extra_nonce,omitempty"`
 }
 
 /*
@@ -53,18 +54,34 @@ func (c *Client) NewTxn(inputs []Output,
 		return nil
 	}
 
-	if idx < 0 || idx >= TXN_NUM_INPUTS {
+	if len(inputs) != c.Params.TxnNumInputs {
 		return nil
 	}
 
-	// gather public keys and commitments
+	if idx < 0 || idx >= len(inputs) {
+		return nil
+	}
+
+	// gather public keys, commitments and heights
 	pks := []ECCPoint{}
 	ics := []ECCPoint{}
 	hashes := []SHA256Sum{}
+	heights := []uint64{}
 	for _, inp := range inputs {
+		height, err := c.MainOutputHeight(inp.Hash())
+		if err != nil {
+			return nil
+		}
+
 		pks = append(pks, inp.DestKey)
 		ics = append(ics, inp.Commit.ECCPoint)
 		hashes = append(hashes, inp.Hash())
+		heights = append(heights, height)
+	}
+
+	version, err := c.Params.RingHashToPtVersion(heights)
+	if err != nil {
+		return nil
 	}
 
 	outputs, blindSum := BuildOutputs(amts, rcpts)
@@ -77,44 +94,38 @@ func (c *Client) NewTxn(inputs []Output,
 		},
 		Sig: OZRS{},
 	}
-	txn.OZRSSign(pks, ics, sk, yi, idx, blindSum)
+	txn.OZRSSign(pks, ics, sk, yi, idx, blindSum, version)
 
 	return txn
 }
 
 /*
- * Builds a new coinbase txn given the block sequence, total block fees, and the
- * destination address.
+ * Builds a new coinbase txn given the total coinbase value, the block reward
+ * plus fees, and the destination address.
  */
-func NewCoinbaseTxn(address WalletPublicKey, seqNum, fee uint64) Txn {
+func NewCoinbaseTxn(address WalletPublicKey, coinbase uint64) Txn {
 	tpk := address.TPK
 	ppk := address.PPK
 
-	zero := &big.Int{}
-	coinbase := CoinbaseValue(seqNum) + fee
-	coinbaseBytes := UIntBytes(coinbase)
-	commit := PedersenSum(zero.Bytes(), coinbaseBytes)
-
 	// Public Key
 	r := RandomBytes()
-	rGx, rGy := CURVE.ScalarBaseMult(r.Bytes())
+	rG := CURVE.BaseMult(r.Bytes())
 
 	// Destination Key
-	secx, secy := CURVE.Params().ScalarMult(tpk.X, tpk.Y, r.Bytes())
-	h := Hash(ECCPoint{secx, secy}.Bytes())
-	dkx, dky := CURVE.Params().ScalarBaseMult(h.Bytes())
-	dkx, dky = CURVE.Params().Add(dkx, dky, ppk.X, ppk.Y)
-
-	ss := [RANGE_PROOF_LENGTH][2]*big.Int{}
-	for i, pair := range ss {
-		for j := range pair {
-			ss[i][j] = zero
-		}
-	}
-	rs := [TXN_NUM_INPUTS]*big.Int{}
-	for i := range rs {
-		rs[i] = zero
-	}
+	h := Hash(CURVE.Mult(tpk, r.Bytes()).Bytes())
+	dk := CURVE.Add(CURVE.BaseMult(h.Bytes()), ppk)
+
+	return coinbaseTxn(rG, dk, coinbase)
+}
+
+/*
+ * Builds a coinbase txn paying `coinbase` to an already derived one-time
+ * public key and destination key.
+ */
+func coinbaseTxn(publicKey, destKey ECCPoint, coinbase uint64) Txn {
+	zero := &big.Int{}
+	coinbaseBytes := UIntBytes(coinbase)
+	commit := PedersenSum(zero.Bytes(), coinbaseBytes)
 
 	return Txn{
 		Body: TxnBody{
@@ -123,22 +134,17 @@ func NewCoinbaseTxn(address WalletPublicKey, seqNum, fee uint64) Txn {
 			},
 			Outputs: []Output{
 				Output{
-					PublicKey: ECCPoint{rGx, rGy},
-					DestKey:   ECCPoint{dkx, dky},
+					PublicKey: publicKey,
+					DestKey:   destKey,
 					BlindSeed: ECCPoint{zero, zero},
 					Commit: Commitment{
-						ECCPoint: commit,
-						RangeProof: RangeProof{
-							Ss: ss,
-						},
+						ECCPoint:   commit,
+						RangeProof: RangeProof{},
 					},
 				},
 			},
 		},
-		Sig: OZRS{
-			Rs: rs,
-			Ss: rs,
-		},
+		Sig: OZRS{},
 	}
 }
 
@@ -155,31 +161,28 @@ func BuildOutputs(amts []uint64, rcpts []WalletPublicKey) ([]Output, *big.Int) {
 
 		// Compute transaction public key
 		r := RandomBytes()
-		pkx, pky := CURVE.Params().ScalarBaseMult(r.Bytes())
+		pk := CURVE.BaseMult(r.Bytes())
 
 		// Compute destination key
-		secx, secy := CURVE.Params().ScalarMult(tpk.X, tpk.Y, r.Bytes())
-		h := Hash(ECCPoint{secx, secy}.Bytes())
-		dkx, dky := CURVE.Params().ScalarBaseMult(h[:])
-		dkx, dky = CURVE.Params().Add(dkx, dky, ppk.X, ppk.Y)
+		h := Hash(CURVE.Mult(tpk, r.Bytes()).Bytes())
+		dk := CURVE.Add(CURVE.BaseMult(h[:]), ppk)
 
 		// Compute blind seed
 		q := RandomBytes()
-		qGx, qGy := CURVE.Params().ScalarBaseMult(q.Bytes())
+		qG := CURVE.BaseMult(q.Bytes())
 
 		// Compute target blinding factor
-		qBx, qBy := CURVE.Params().ScalarMult(ppk.X, ppk.Y, q.Bytes())
-		blind := Hash(ECCPoint{qBx, qBy}.Bytes())
+		blind := Hash(CURVE.Mult(ppk, q.Bytes()).Bytes())
 
 		commit := RangeCommit(amts[i], blind.Int())
 
 		blindSum.Add(blindSum, blind.Int())
-		blindSum.Mod(blindSum, CURVE.Params().N)
+		blindSum.Mod(blindSum, CURVE.Order())
 
 		output := Output{
-			PublicKey: ECCPoint{pkx, pky},
-			DestKey:   ECCPoint{dkx, dky},
-			BlindSeed: ECCPoint{qGx, qGy},
+			PublicKey: pk,
+			DestKey:   dk,
+			BlindSeed: qG,
 			Commit:    commit,
 		}
 
@@ -201,7 +204,7 @@ func (c *Client) ValidTxns(b Block) bool {
 				return false
 			}
 		} else {
-			if !ValidTxn(txn) {
+			if !c.ValidTxn(txn) {
 				log.Println("Invalid Txn")
 				return false
 			}
@@ -214,21 +217,37 @@ func (c *Client) ValidTxns(b Block) bool {
 /*
  * Less intensive txn validations.
  */
-func ValidTxn(txn Txn) bool {
-
-	if txn.Body.Inputs == nil || len(txn.Body.Inputs) != TXN_NUM_INPUTS {
-		log.Println("Invalid number of txn inputs")
+func (c *Client) ValidTxn(txn Txn) bool {
+	err := c.validTxn(txn)
+	if err != nil {
+		log.Println(err)
 		return false
 	}
 
+	return true
+}
+
+func (c *Client) validTxn(txn Txn) error {
+	if txn.Body.Inputs == nil || len(txn.Body.Inputs) != c.Params.TxnNumInputs {
+		return errors.New("Invalid number of txn inputs")
+	}
+
 	if txn.Body.Outputs == nil || len(txn.Body.Outputs) != TXN_NUM_OUTPUTS {
-		log.Println("Invalid number of txn outputs")
-		return false
+		return errors.New("Invalid number of txn outputs")
 	}
 
-	if len(txn.Json()) >= MAX_BLOCK_SIZE {
-		log.Println("TXN TOO BIG")
-		return false
+	// Only miners need extra search space
+	if txn.Body.ExtraNonce != 0 {
+		return errors.New("Extra nonce outside of coinbase")
+	}
+
+	if txn.Size() >= MAX_BLOCK_SIZE {
+		return errors.New("Txn exceeds MAX_BLOCK_SIZE")
+	}
+
+	// A preimage at infinity would be shared by every key
+	if !txn.Sig.Preimage.Valid() {
+		return errors.New("Txn has an invalid preimage")
 	}
 
 	for _, output := range txn.Body.Outputs {
@@ -236,12 +255,19 @@ func ValidTxn(txn Txn) bool {
 			output.DestKey.Empty() ||
 			output.BlindSeed.Empty() ||
 			output.Commit.Empty() {
-			log.Println("Missing data")
-			return false
+			return errors.New("Txn output missing data")
+		}
+
+		if !output.PublicKey.Valid() || !output.DestKey.Valid() {
+			return errors.New("Txn output has an invalid key")
+		}
+
+		if output.IsCoinbase() {
+			return errors.New("Txn output has a coinbase blind seed")
 		}
 	}
 
-	return true
+	return nil
 }
 
 /*
@@ -264,7 +290,7 @@ func ValidCoinbaseTxn(txn Txn) bool {
 		return false
 	}
 
-	if len(txn.Json()) >= MAX_BLOCK_SIZE {
+	if txn.Size() >= MAX_BLOCK_SIZE {
 		log.Println("Txn exceeds MAX_BLOCK_SIZE")
 		return false
 	}
@@ -279,6 +305,16 @@ func ValidCoinbaseTxn(txn Txn) bool {
 		return false
 	}
 
+	if !output.PublicKey.Valid() || !output.DestKey.Valid() {
+		log.Println("Invalid output key")
+		return false
+	}
+
+	if !output.IsCoinbase() {
+		log.Println("Coinbase blind seed must be zero")
+		return false
+	}
+
 	return true
 }
 
@@ -293,24 +329,24 @@ func (c *Client) VerifyTxns(b Block, mainPath, sidePath []SHA256Sum) bool {
 	}
 
 	// Compute invalid unspent txns and preimages
-	mainTxns, mainPimgs, err := c.ForkTxnsAndPreimages(mainPath)
+	mainTxns, mainPimgs, _, err := c.ForkTxnsAndPreimages(mainPath)
 	if err != nil {
 		log.Println(err)
 		return false
 	}
 
-	sideTxns, sidePimgs, err := c.ForkTxnsAndPreimages(sidePath)
+	sideTxns, sidePimgs, sideHeights, err := c.ForkTxnsAndPreimages(sidePath)
 	if err != nil {
 		log.Println(err)
 		return false
 	}
 
-	coinbase := CoinbaseValue(b.Header.SeqNum)
+	coinbase := c.Params.CoinbaseValue(b.Header.SeqNum)
 
 	// Check validity of each transaction
 	for i, txn := range b.Txns {
 		if i != 0 {
-			if !c.VerifyTxn(txn, mainTxns, sideTxns, mainPimgs, sidePimgs) {
+			if !c.VerifyTxn(txn, b.Header.SeqNum, mainTxns, sideTxns, mainPimgs, sidePimgs, sideHeights) {
 				log.Println("Invalid Txn")
 				return false
 			}
@@ -328,62 +364,95 @@ func (c *Client) VerifyTxns(b Block, mainPath, sidePath []SHA256Sum) bool {
 }
 
 /*
- * More intensive txn validation.
+ * More intensive txn validation, for a txn included in a block at `height`.
+ * `sideHeights` gives the heights of outputs on the side fork.
  */
-func (c *Client) VerifyTxn(txn Txn, mainTxns, sideTxns map[SHA256Sum]Output, mainPimgs, sidePimgs map[SHA256Sum]struct{}) bool {
+func (c *Client) VerifyTxn(txn Txn, height uint64, mainTxns, sideTxns map[SHA256Sum]Output, mainPimgs, sidePimgs map[SHA256Sum]struct{}, sideHeights map[SHA256Sum]uint64) bool {
+	err := c.verifyTxn(txn, height, mainTxns, sideTxns, mainPimgs, sidePimgs, sideHeights)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+
+	return true
+}
+
+func (c *Client) verifyTxn(txn Txn, height uint64, mainTxns, sideTxns map[SHA256Sum]Output, mainPimgs, sidePimgs map[SHA256Sum]struct{}, sideHeights map[SHA256Sum]uint64) error {
 	// Check that maps are all nil or all non-nil
 	forking := false
 	if mainTxns != nil &&
 		mainPimgs != nil &&
 		sideTxns != nil &&
-		sidePimgs != nil {
+		sidePimgs != nil &&
+		sideHeights != nil {
 
 		forking = true
 	} else if !(mainTxns == nil &&
 		mainPimgs == nil &&
 		sideTxns == nil &&
-		sidePimgs == nil) {
+		sidePimgs == nil &&
+		sideHeights == nil) {
 
 		panic("All maps should be nil or non-nil")
 	}
 
-	// Check for preimage
+	// Check for preimage, ignoring spends that only happened on the main fork
 	pimg := Hash(txn.Sig.Preimage.Bytes())
 	found := c.GetPreimage(pimg)
 
 	if forking {
 		_, mainok := mainPimgs[pimg]
-		if found || mainok {
-			return false
+		_, sideok := sidePimgs[pimg]
+		if (found && !mainok) || sideok {
+			return errors.New("Txn preimage already spent")
 		}
 	} else if found {
-		return false
+		return errors.New("Txn preimage already spent")
 	}
 
 	// Get inputs
 	inputs := []Output{}
+	heights := []uint64{}
 	for _, inp := range txn.Body.Inputs {
 		output, err := c.FindOutput(inp)
 		if err != nil {
-			log.Println("Could not load txn")
-			return false
+			return errors.New("Could not load txn input")
 		}
 
 		_, err = c.MapToBlock(inp)
 
-		// Check main forks for output
+		// Output must be on the main chain below the fork or on the side fork
 		if forking {
 			_, mainok := mainTxns[inp]
-			if err != nil || mainok {
-				return false
+			_, sideok := sideTxns[inp]
+			if (err != nil || mainok) && !sideok {
+				return errors.New("Txn input not on this fork")
 			}
 
 		} else if err != nil {
-			log.Println("No map:", err)
-			return false
+			return errors.New("Txn input not on the main chain")
+		}
+
+		inpHeight, ok := sideHeights[inp]
+		if !ok {
+			inpHeight, err = c.MainOutputHeight(inp)
+			if err != nil {
+				return errors.New("Could not find txn input height")
+			}
+		}
+
+		// Coinbase outputs must mature before spending or use as decoys
+		if output.IsCoinbase() && height < inpHeight+c.Params.CoinbaseMaturity {
+			return errors.New("Txn input is an immature coinbase")
 		}
 
 		inputs = append(inputs, *output)
+		heights = append(heights, inpHeight)
+	}
+
+	version, err := c.Params.RingHashToPtVersion(heights)
+	if err != nil {
+		return err
 	}
 
 	// Get Public Keys and commitments
@@ -393,17 +462,17 @@ func (c *Client) VerifyTxn(txn Txn, mainTxns, sideTxns map[SHA256Sum]Output, mai
 		ics = append(ics, inp.Commit.ECCPoint)
 	}
 
-	if !txn.VerifyOZRS(pks, ics) {
-		return false
+	if !txn.VerifyOZRS(pks, ics, version) {
+		return errors.New("Invalid ring signature")
 	}
 
 	for _, output := range txn.Body.Outputs {
-		if !output.Commit.RangeProof.Verify() {
-			return false
+		if !output.Commit.Verify() {
+			return errors.New("Invalid range proof")
 		}
 	}
 
-	return true
+	return nil
 }
 
 /*
@@ -411,28 +480,11 @@ func (c *Client) VerifyTxn(txn Txn, mainTxns, sideTxns map[SHA256Sum]Output, mai
  */
 func (c *Client) VerifyCoinbaseTxn(txn Txn, coinbase uint64) bool {
 	coinbaseBytes := UIntBytes(coinbase)
-	cx, cy := CURVE.Params().ScalarMult(H.X, H.Y, coinbaseBytes)
-	cy.Neg(cy)
+	cH := CURVE.Mult(H, coinbaseBytes).Neg()
 
 	commit := txn.Body.Outputs[0].Commit
-	cx, cy = CURVE.Params().Add(commit.X, commit.Y, cx, cy)
-
-	zero := &big.Int{}
-
-	return zero.Cmp(cx) == 0 && zero.Cmp(cy) == 0
-}
 
-/*
- * The json bytes to be signed.
- */
-func (txn Txn) BodyJson() []byte {
-	b, err := json.Marshal(txn.Body)
-	if err != nil {
-		log.Println(err)
-		panic("Could not marshal txn body")
-	}
-
-	return b
+	return CURVE.Add(commit.ECCPoint, cH).IsIdentity()
 }
 
 /*
@@ -449,20 +501,28 @@ func (txn Txn) Json() []byte {
 }
 
 /*
- * Hash of full txn json.
+ * Hash of the full txn's canonical encoding.
  */
 func (txn Txn) Hash() SHA256Sum {
-	return Hash(txn.Json())
+	return Hash(txn.Bytes())
+}
+
+/*
+ * The serialized size of the txn in bytes.
+ */
+func (txn Txn) Size() int {
+	return len(txn.Bytes())
 }
 
 /*
  * Computes the preimage of a public key, H_p(pk), and multiplies it by the
- * secret key. If `sk` is nil, the base point is simply returned.
+ * secret key. If `sk` is nil, the base point is simply returned.  The hash to
+ * point `version` is chosen by `RingHashToPtVersion`.
  */
-func Preimage(pk ECCPoint, sk *big.Int) ECCPoint {
-	hp := HashToPt(pk.Bytes())
+func Preimage(pk ECCPoint, sk *big.Int, version uint8) ECCPoint {
+	hp := hashToPtVersion(version, pk.Bytes())
 	if sk != nil {
-		hp.X, hp.Y = CURVE.Params().ScalarMult(hp.X, hp.Y, sk.Bytes())
+		hp = CURVE.Mult(hp, sk.Bytes())
 	}
 
 	return hp
@@ -530,11 +590,9 @@ func (c *Client) LoadTxn(hash SHA256Sum) (*Txn, error) {
 		return nil, err
 	}
 
-	// Iterate though transactions to find preimage
 	for _, t := range block.Txns {
-		if hash == Hash(t.Sig.Preimage.Bytes()) {
-			*txn = t
-			return txn, nil
+		if hash == t.Hash() {
+			return &t, nil
 		}
 	}
 
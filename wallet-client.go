@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strings"
 )
 
 /*
@@ -181,6 +183,11 @@ func (wc *WalletClient) POST(relativeUrl string, data []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	// Surface the server's reason for failed requests
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(strings.TrimSpace(string(body)))
+	}
+
 	return body, nil
 }
 
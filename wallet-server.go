@@ -7,7 +7,9 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
+	"math/big"
 	"net/http"
+	"path/filepath"
 	"time"
 )
 
@@ -30,14 +32,14 @@ type WalletServer struct {
 	Outputs    []OutputPlaintext
 }
 
-func NewWalletServer(miningAddress, svpAddress, walletAddress, password string) *WalletServer {
+func NewWalletServer(params *ChainParams, miningAddress, svpAddress, walletAddress, password string) *WalletServer {
 	log.Println("Starting client with", svpAddress, walletAddress)
 	ws := &WalletServer{
-		Client:     NewSPV(svpAddress, walletAddress, password),
+		Client:     NewSPV(params, svpAddress, walletAddress, password),
 		Address:    walletAddress,
-		AuthDBPath: "db/wallet-auth.db",
-		PrivPDBath: "db/wallet-priv.db",
-		TxnDBPath:  "db/wallet-txn.db",
+		AuthDBPath: filepath.Join(params.DataDir, "wallet-auth.db"),
+		PrivPDBath: filepath.Join(params.DataDir, "wallet-priv.db"),
+		TxnDBPath:  filepath.Join(params.DataDir, "wallet-txn.db"),
 	}
 
 	log.Println("Registering with", miningAddress)
@@ -97,11 +99,19 @@ type TrackingKeysMsg struct {
 	TrackingKeys []WalletTrackingKey `json:"track_keys"`
 }
 
+/*
+ * `Balance` only counts spendable funds, coinbase funds that have not reached
+ * maturity are reported in `Immature`.
+ */
 type BalanceMsg struct {
-	Balance uint64            `json:"balance"`
-	Outputs []OutputPlaintext `json:"outputs"`
+	Balance  uint64            `json:"balance"`
+	Immature uint64            `json:"immature"`
+	Outputs  []OutputPlaintext `json:"outputs"`
 }
 
+/*
+ * A zero `Fee` asks the wallet server to estimate one.
+ */
 type SignMsg struct {
 	Address WalletPublicKey `json:"address"`
 	Amount  uint64          `json:"amount"`
@@ -168,9 +178,15 @@ func (ws *WalletServer) handleBalance(w http.ResponseWriter, r *http.Request) {
 	}
 
 	balance := uint64(0)
+	immature := uint64(0)
 	lightOutputs := []OutputPlaintext{}
 	for _, o := range ws.Outputs {
-		balance += o.Amount
+		if ws.Spendable(o) {
+			balance += o.Amount
+		} else {
+			immature += o.Amount
+		}
+
 		lo := OutputPlaintext{
 			HashPub: o.HashPub,
 			Time:    o.Time,
@@ -179,11 +195,12 @@ func (ws *WalletServer) handleBalance(w http.ResponseWriter, r *http.Request) {
 		lightOutputs = append(lightOutputs, lo)
 	}
 
-	log.Println("balance:", balance)
+	log.Println("balance:", balance, "immature:", immature)
 
 	res := BalanceMsg{
-		Balance: balance,
-		Outputs: lightOutputs,
+		Balance:  balance,
+		Immature: immature,
+		Outputs:  lightOutputs,
 	}
 	jsonWrite(w, res)
 }
@@ -251,6 +268,33 @@ func (ws *WalletServer) handleSign(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+
+	// Price the txn from recent blocks if no fee was given.  The draft only
+	// needs a ring of the right size, so any output covering the amount will do.
+	if req.Fee == 0 {
+		draftFunding, _ := ws.findFundingTxn(req.Amount)
+		if draftFunding == nil {
+			err = errors.New("Cannot find funding txn")
+			log.Println(err)
+			http.Error(w, err.Error(), 422)
+			return
+		}
+
+		draftInputs, draftIdx, err := ws.RandomOutputs(*draftFunding.Output, draftFunding.Height)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		req.Fee, err = ws.estimateTxnFee(draftInputs, draftIdx, req.Address, req.Amount)
+		if err != nil {
+			err = errors.New("Cannot estimate fee: " + err.Error())
+			log.Println(err)
+			http.Error(w, err.Error(), 422)
+			return
+		}
+	}
 	total := req.Amount + req.Fee
 
 	// Find funding transaction
@@ -263,15 +307,13 @@ func (ws *WalletServer) handleSign(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Println("Finding random outputs")
-	inputs, err := ws.RandomOutputs()
+	inputs, idx, err := ws.RandomOutputs(*fundingTxn.Output, fundingTxn.Height)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	inputs[0] = *fundingTxn.Output
-
 	sk := fundingTxn.Output.ComputeTxnPrivateKey(*priv)
 	yi := fundingTxn.Output.ComputeBlindingFactor(*priv)
 
@@ -285,59 +327,143 @@ func (ws *WalletServer) handleSign(w http.ResponseWriter, r *http.Request) {
 		rcpts = []WalletPublicKey{req.Address, ws.Privs[0].PublicKey()}
 	}
 
-	txn := ws.NewTxn(inputs, sk, yi, 0, amts, rcpts, req.Fee)
+	txn := ws.NewTxn(inputs, sk, yi, idx, amts, rcpts, req.Fee)
+	if txn == nil {
+		err = errors.New("Cannot build txn from funding txn")
+		log.Println(err)
+		http.Error(w, err.Error(), 422)
+		return
+	}
 
-	ws.TxnChan <- *txn
+	err = ws.SubmitTxn(*txn)
+	if err != nil {
+		http.Error(w, "Txn rejected: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	jsonWrite(w, txn)
 }
 
-func (c *Client) RandomOutputs() ([]Output, error) {
-	outputs := []Output{}
+/*
+ * Estimates the fee for sending `amount` to `addr` within `FEE_DEFAULT_TARGET`
+ * blocks.  The size is measured from an unfunded draft of the txn, which only
+ * differs from the final txn in its signature values and fee.
+ */
+func (ws *WalletServer) estimateTxnFee(inputs []Output, idx int, addr WalletPublicKey, amount uint64) (uint64, error) {
+	rate, err := ws.EstimateFee(FEE_DEFAULT_TARGET)
+	if err != nil {
+		return 0, err
+	}
+
+	amts := []uint64{amount, 0}
+	rcpts := []WalletPublicKey{addr, ws.Privs[0].PublicKey()}
+	draft := ws.NewTxn(inputs, RandomBytes().Int(), &big.Int{}, idx, amts, rcpts, 0)
+	if draft == nil {
+		return 0, errors.New("Could not build draft txn")
+	}
+
+	return rate * uint64(draft.Size()), nil
+}
+
+/*
+ * Builds a ring of `TxnNumInputs` outputs from `funding`, created at
+ * `fundingHeight`, and decoys drawn at random from the main chain.  Returns the
+ * ring in random order along with the index of `funding`.  A ring may not mix
+ * hash to point versions, so every decoy is from the same side of
+ * `HashToPtHeight` as the funding output.
+ */
+func (c *Client) RandomOutputs(funding Output, fundingHeight uint64) ([]Output, int, error) {
+	type candidate struct {
+		hash   SHA256Sum
+		block  SHA256Sum
+		height uint64
+	}
+
+	fundingHash := funding.Hash()
+	legacy := fundingHeight < c.Params.HashToPtHeight
+
+	// Gather every mapped output of the funding output's version.  Keys also
+	// map txn hashes, which match no output when drawn.
+	candidates := []candidate{}
+	heights := make(map[SHA256Sum]uint64)
 	iter := c.dbm.mapDB.NewIterator(nil, nil)
 	for iter.Next() {
-		pimgHash := iter.Key()
-		blockHash := iter.Value()
-
-		log.Println("pimgHash:", string(pimgHash))
-		log.Println("blockHash:", string(blockHash))
+		if len(iter.Key()) != SHA256_SUM_LENGTH || len(iter.Value()) != SHA256_SUM_LENGTH {
+			continue
+		}
 
-		if len(pimgHash) != SHA256_SUM_LENGTH || len(blockHash) != SHA256_SUM_LENGTH {
+		cand := candidate{}
+		copy(cand.hash[:], iter.Key())
+		copy(cand.block[:], iter.Value())
+		if cand.hash == fundingHash {
 			continue
 		}
 
-		blockSum := SHA256Sum{}
-		for i, b := range blockHash {
-			blockSum[i] = b
+		height, ok := heights[cand.block]
+		if !ok {
+			header, err := c.GetHeader(cand.block)
+			if err != nil {
+				continue
+			}
+
+			height = header.SeqNum
+			heights[cand.block] = height
 		}
+		cand.height = height
 
-		log.Println("finding block")
-		block, err := c.FindBlock(blockSum)
-		if err != nil {
+		// Decoys must share the funding output's hash to point version
+		if (height < c.Params.HashToPtHeight) != legacy {
 			continue
 		}
 
-		log.Println("INPUT BLOCK HEADER:", block.Header)
+		candidates = append(candidates, cand)
+	}
+	iter.Release()
+
+	err := iter.Error()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Draw decoys without replacement until the ring is full
+	nextHeight := c.LastHeader.SeqNum + 1
+	decoys := []Output{}
+	for len(candidates) > 0 && len(decoys) < c.Params.TxnNumInputs-1 {
+		i := RandomIndex(len(candidates))
+		cand := candidates[i]
+		candidates[i] = candidates[len(candidates)-1]
+		candidates = candidates[:len(candidates)-1]
+
+		block, err := c.FindBlock(cand.block)
+		if err != nil || block == nil {
+			continue
+		}
+
+		// Immature coinbase outputs may not be decoys
+		mature := nextHeight >= cand.height+c.Params.CoinbaseMaturity
 
 		for _, txn := range block.Txns {
-		NextOutput:
 			for _, output := range txn.Body.Outputs {
-				for i, b := range output.Hash() {
-					if pimgHash[i] != b {
-						goto NextOutput
-					}
+				if output.Hash() != cand.hash || (output.IsCoinbase() && !mature) {
+					continue
 				}
 
-				outputs = append(outputs, output)
-
-				if len(outputs) == TXN_NUM_INPUTS {
-					return outputs, nil
-				}
+				decoys = append(decoys, output)
 			}
 		}
 	}
 
-	return nil, errors.New("Not enough txns found")
+	if len(decoys) < c.Params.TxnNumInputs-1 {
+		return nil, 0, errors.New("Not enough txns found")
+	}
+
+	// Hide the funding output at a random position among the decoys
+	idx := RandomIndex(c.Params.TxnNumInputs)
+	outputs := append([]Output{}, decoys[:idx]...)
+	outputs = append(outputs, funding)
+	outputs = append(outputs, decoys[idx:]...)
+
+	return outputs, idx, nil
 }
 
 func (ws *WalletServer) handleNewBlock(w http.ResponseWriter, r *http.Request) {
@@ -588,11 +714,27 @@ func jsonWrite(w http.ResponseWriter, o interface{}) {
 	w.Write(b)
 }
 
+/*
+ * Checks that an output could be spent in the next block, which requires
+ * coinbase outputs to have matured.
+ */
+func (ws *WalletServer) Spendable(op OutputPlaintext) bool {
+	if !op.Output.IsCoinbase() {
+		return true
+	}
+
+	return ws.LastHeader.SeqNum+1 >= op.Height+ws.Params.CoinbaseMaturity
+}
+
 func (ws *WalletServer) findFundingTxn(amount uint64) (*OutputPlaintext, *WalletPrivateKey) {
 	var skPtr *WalletPrivateKey
 	fundingTxn := &OutputPlaintext{}
 	found := false
 	for _, txn := range ws.Outputs {
+		if !ws.Spendable(txn) {
+			continue
+		}
+
 		if amount <= txn.Amount {
 			if !found || txn.Amount < fundingTxn.Amount {
 				*fundingTxn = txn
@@ -618,7 +760,7 @@ func (ws *WalletServer) findFundingTxn(amount uint64) (*OutputPlaintext, *Wallet
 }
 
 func (ws *WalletServer) saveMyTxns(b Block) error {
-	coinbase := CoinbaseValue(b.Header.SeqNum)
+	coinbase := ws.Params.CoinbaseValue(b.Header.SeqNum)
 	for _, txn := range b.Txns {
 		coinbase += txn.Body.Fee
 	}
@@ -0,0 +1,175 @@
+package ozcoin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+/*
+ * Builds a wallet server around `c` with a fresh wallet, returning the wallet's
+ * auth token.
+ */
+func newTestWalletServer(t *testing.T, c *Client) (*WalletServer, SHA256Sum) {
+	dir := filepath.Dir(c.HeaderDBPath)
+	ws := &WalletServer{
+		Client:     c,
+		AuthDBPath: filepath.Join(dir, "wallet-auth.db"),
+		PrivPDBath: filepath.Join(dir, "wallet-priv.db"),
+		TxnDBPath:  filepath.Join(dir, "wallet-txn.db"),
+	}
+	ws.authDB = ws.OpenAuthDB()
+	ws.privDB = ws.OpenPrivDB()
+	ws.txnDB = ws.OpenTxnDB()
+
+	token, err := ws.Create("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ws, token
+}
+
+func TestSignLegacyOutput(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	// Outputs up to and including height TxnNumInputs are legacy
+	c.Params.HashToPtHeight = uint64(c.Params.TxnNumInputs) + 1
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws, token := newTestWalletServer(t, c)
+	addr := ws.Privs[0].PublicKey()
+
+	// Only legacy outputs belong to the wallet
+	for i := 0; i < c.Params.TxnNumInputs; i++ {
+		block := mineTestBlock(c, c.LastHeader, addr, nil)
+		success, err := c.ExtendMainChain(block.Header, &block)
+		if !success || err != nil {
+			t.Fatal("Could not extend main chain:", err)
+		}
+
+		err = ws.saveMyTxns(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	other := NewPrivateKey().PublicKey()
+	extendTestChain(t, c, other, int(c.Params.CoinbaseMaturity))
+
+	go c.run()
+
+	sign := SignMsg{
+		Address: other,
+		Amount:  1000,
+		Fee:     10,
+	}
+	body, err := json.Marshal(sign)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/sign", bytes.NewReader(body))
+	req.AddCookie(&http.Cookie{
+		Name:  "X-Wallet-Token",
+		Value: base64.StdEncoding.EncodeToString(token[:]),
+	})
+
+	rec := httptest.NewRecorder()
+	ws.handleSign(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Sign failed with %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var txn Txn
+	err = json.Unmarshal(rec.Body.Bytes(), &txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, inp := range txn.Body.Inputs {
+		height, err := c.MainOutputHeight(inp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if height >= c.Params.HashToPtHeight {
+			t.Error("Legacy ring has a decoy past the switch")
+		}
+	}
+
+	_, err = c.GetTxnPool(txn.Hash())
+	if err != nil {
+		t.Error("Signed txn not admitted to pool")
+	}
+}
+
+func TestRandomOutputs(t *testing.T) {
+	c, cleanup := newTestClient(t)
+	defer cleanup()
+
+	err := c.InitGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Plenty of mature outputs to draw decoys from
+	addr := NewPrivateKey().PublicKey()
+	outputs := extendTestChain(t, c, addr, 3*c.Params.TxnNumInputs)
+	extendTestChain(t, c, addr, int(c.Params.CoinbaseMaturity))
+
+	funding := outputs[0]
+	positions := make(map[int]struct{})
+	rings := make(map[SHA256Sum]struct{})
+	for i := 0; i < 20; i++ {
+		ring, idx, err := c.RandomOutputs(funding, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(ring) != c.Params.TxnNumInputs {
+			t.Fatal("Expected ring of", c.Params.TxnNumInputs, "got", len(ring))
+		}
+		if ring[idx].Hash() != funding.Hash() {
+			t.Fatal("Funding output not at the returned index")
+		}
+
+		members := make(map[SHA256Sum]struct{})
+		for _, output := range ring {
+			members[output.Hash()] = SIGNAL
+		}
+		if len(members) != len(ring) {
+			t.Fatal("Ring repeats an output")
+		}
+
+		positions[idx] = SIGNAL
+		rings[hashRing(ring)] = SIGNAL
+	}
+
+	// Neither the funding output's position nor the decoys are fixed
+	if len(positions) == 1 {
+		t.Error("Funding output always at the same index")
+	}
+	if len(rings) == 1 {
+		t.Error("Every ring has the same decoys in the same order")
+	}
+}
+
+func hashRing(ring []Output) SHA256Sum {
+	data := []byte{}
+	for _, output := range ring {
+		hash := output.Hash()
+		data = append(data, hash[:]...)
+	}
+
+	return Hash(data)
+}
@@ -3,16 +3,25 @@ package main
 import (
 	"github.com/cfromknecht/ozcoin"
 
+	"flag"
 	"log"
 )
 
 func main() {
-	miningAddress := "127.0.0.1:6000"
-	svpAddress := "127.0.0.1:6001"
-	walletAddress := "127.0.0.1:6002"
+	net := flag.String("net", "mainnet", "network to join: mainnet, testnet, regtest, or regtest-secp256k1")
+	flag.Parse()
+
+	params, err := ozcoin.ParamsForNet(*net)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	miningAddress := "127.0.0.1:" + params.MinerPort
+	svpAddress := "127.0.0.1:" + params.SPVPort
+	walletAddress := "127.0.0.1:" + params.WalletPort
 	password := "test"
 
-	ws := ozcoin.NewWalletServer(miningAddress, svpAddress, walletAddress, password)
+	ws := ozcoin.NewWalletServer(params, miningAddress, svpAddress, walletAddress, password)
 	if ws == nil {
 		log.Println("Could not create wallet server")
 	}